@@ -0,0 +1,119 @@
+package portforwarder
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HTTPClient returns the client which routes every connection through a stream to the target pod port,
+// the host of the request URLs does not matter, e.g. http://api/health.
+// The pod is resolved again when dialing it fails, e.g. after it was restarted.
+// Dialing fails and the idle connections are closed once the context is done.
+func (pf *PortForwarder) HTTPClient(ctx context.Context, target *TargetPod) (*http.Client, error) {
+	d, err := pf.newTargetDialer(target)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := &http.Transport{
+		DialContext: func(dialCtx context.Context, _, _ string) (net.Conn, error) {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+			return d.dial(dialCtx)
+		},
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+
+	if ctx.Done() != nil {
+		go func() {
+			<-ctx.Done()
+			transport.CloseIdleConnections()
+		}()
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// GRPCDialer returns the dial function for grpc.WithContextDialer,
+// which routes every connection through a stream to the target pod port,
+// the pod is resolved again when dialing it fails.
+// The ready-made dial option is returned by grpcdial.DialOption, so this package does not depend on gRPC.
+//
+//	dialer, err := pf.GRPCDialer(target)
+//	conn, err := grpc.Dial("api", grpc.WithContextDialer(dialer), grpc.WithTransportCredentials(insecure.NewCredentials()))
+func (pf *PortForwarder) GRPCDialer(target *TargetPod) (func(ctx context.Context, addr string) (net.Conn, error), error) {
+	d, err := pf.newTargetDialer(target)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(ctx context.Context, _ string) (net.Conn, error) {
+		return d.dial(ctx)
+	}, nil
+}
+
+// targetDialer dials the port of the last resolved pod of the target
+// and resolves the pod again when that fails
+type targetDialer struct {
+	pf      *PortForwarder
+	target  *TargetPod
+	onEvent EventHandler
+	mx      sync.Mutex
+	podName string
+}
+
+func (pf *PortForwarder) newTargetDialer(target *TargetPod) (*targetDialer, error) {
+	target.applyDefaults(pf.namespace())
+	if err := target.validateDial(target.Port); err != nil {
+		return nil, err
+	}
+
+	return &targetDialer{
+		pf:      pf,
+		target:  target,
+		onEvent: pf.withLogging(target.OnEvent, target.Namespace),
+	}, nil
+}
+
+func (d *targetDialer) dial(ctx context.Context) (net.Conn, error) {
+	if podName := d.resolvedPodName(); podName != "" {
+		conn, err := d.pf.dialPod(ctx, d.target.Namespace, podName, d.target.Port, d.onEvent)
+		if err == nil {
+			return conn, nil
+		}
+
+		d.onEvent.emit(StreamError{PodName: podName, RemotePort: d.target.Port, Err: err})
+		if ctx.Err() != nil {
+			return nil, err
+		}
+	}
+
+	d.onEvent.emit(Resolving{Namespace: d.target.Namespace})
+	podName, err := d.pf.resolvePodName(ctx, d.target)
+	if err != nil {
+		return nil, fmt.Errorf("could not dial a pod: %w", err)
+	}
+	d.onEvent.emit(PodSelected{Namespace: d.target.Namespace, Name: podName})
+	d.setPodName(podName)
+
+	return d.pf.dialPod(ctx, d.target.Namespace, podName, d.target.Port, d.onEvent)
+}
+
+func (d *targetDialer) resolvedPodName() string {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	return d.podName
+}
+
+func (d *targetDialer) setPodName(podName string) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	d.podName = podName
+}
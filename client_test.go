@@ -0,0 +1,159 @@
+package portforwarder
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/rest"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// serveHTTP answers the request of the data stream with the path of the request and the pod port
+func serveHTTP(s httpstream.Stream) {
+	defer s.Close()
+
+	req, err := http.ReadRequest(bufio.NewReader(s))
+	if err != nil {
+		return
+	}
+
+	body := fmt.Sprintf("%s from %s", req.URL.Path, s.Headers().Get(v1.PortHeader))
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Close:         true,
+		ContentLength: int64(len(body)),
+		Body:          io.NopCloser(strings.NewReader(body)),
+	}
+	_ = resp.Write(s)
+}
+
+func getBody(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+
+	resp, err := client.Get(url)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
+
+func TestPortForwarder_HTTPClient(t *testing.T) {
+	ctx := context.TODO()
+
+	var mx sync.Mutex
+	gone := map[string]bool{}
+	srv := (&websocketPodServer{
+		handleData: serveHTTP,
+		podExists: func(path string) bool {
+			mx.Lock()
+			defer mx.Unlock()
+			for pod := range gone {
+				if strings.Contains(path, "/pods/"+pod+"/") {
+					return false
+				}
+			}
+			return true
+		},
+	}).start(t)
+
+	pl := newMockPodProvider(t)
+	pl.EXPECT().listPods(mock.Anything, mock.Anything).Times(1).
+		Return(&v1.PodList{Items: []v1.Pod{readyPod("api-1")}}, nil)
+	pl.EXPECT().listPods(mock.Anything, mock.Anything).Times(1).
+		Return(&v1.PodList{Items: []v1.Pod{readyPod("api-2")}}, nil)
+
+	pf := withCoreClient(t, &PortForwarder{
+		podProvider: pl,
+		restCfg:     &rest.Config{Host: srv.URL, BearerToken: "token"},
+		transport:   TransportWebSocket,
+	})
+
+	recorder := &eventRecorder{}
+	client, err := pf.HTTPClient(ctx, &TargetPod{
+		Port:          8080,
+		LabelSelector: map[string]string{"app": "api"},
+		OnEvent:       recorder.handle,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, "/health from 8080", getBody(t, client, "http://api/health"))
+	assert.Equal(t, "/ready from 8080", getBody(t, client, "http://api/ready"))
+
+	mx.Lock()
+	gone["api-1"] = true
+	mx.Unlock()
+
+	assert.Equal(t, "/health from 8080", getBody(t, client, "http://api/health"))
+
+	var selected []string
+	for _, e := range recorder.recorded() {
+		if e, ok := e.(PodSelected); ok {
+			selected = append(selected, e.Name)
+		}
+	}
+	assert.Equal(t, []string{"api-1", "api-2"}, selected)
+}
+
+func TestPortForwarder_HTTPClient_validation(t *testing.T) {
+	pf := &PortForwarder{restCfg: &rest.Config{}}
+
+	_, err := pf.HTTPClient(context.TODO(), &TargetPod{Name: "api-1"})
+	require.ErrorIs(t, err, ErrTargetPodValidation)
+}
+
+func TestPortForwarder_HTTPClient_contextDone(t *testing.T) {
+	pf := &PortForwarder{restCfg: &rest.Config{}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client, err := pf.HTTPClient(ctx, &TargetPod{Name: "api-1", Port: 8080})
+	require.NoError(t, err)
+	cancel()
+
+	_, err = client.Get("http://api/health")
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestPortForwarder_GRPCDialer(t *testing.T) {
+	ctx := context.TODO()
+	srv := newWebsocketPodServer(t)
+
+	pod := readyPod("api-1")
+	pl := newMockPodProvider(t)
+	pl.EXPECT().getPod(ctx, "default", "api-1").Times(1).Return(&pod, nil)
+
+	pf := withCoreClient(t, &PortForwarder{
+		podProvider: pl,
+		restCfg:     &rest.Config{Host: srv.URL, BearerToken: "token"},
+		transport:   TransportWebSocket,
+	})
+
+	dial, err := pf.GRPCDialer(&TargetPod{Name: "api-1", Port: 9090})
+	require.NoError(t, err)
+
+	for i := 0; i < 2; i++ {
+		conn, err := dial(ctx, "api:9090")
+		require.NoError(t, err)
+
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+
+		resp := make([]byte, len("9090:ping"))
+		_, err = io.ReadFull(conn, resp)
+		require.NoError(t, err)
+		assert.Equal(t, "9090:ping", string(resp))
+		require.NoError(t, conn.Close())
+	}
+}
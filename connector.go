@@ -1,31 +1,244 @@
 package portforwarder
 
 import (
+	"errors"
 	"fmt"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"os"
+	"strings"
 )
 
+// inClusterNamespaceFile is the namespace of the service account mounted into the pod
+const inClusterNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// namespaceConnector is implemented by the connectors which know the default namespace,
+// e.g. the namespace of the kubeconfig context, it is known after Connect
+type namespaceConnector interface {
+	Namespace() string
+}
+
+// ConnectorOption overrides the kubeconfig settings the same way kubectl flags do
+type ConnectorOption func(overrides *clientcmd.ConfigOverrides)
+
+// WithContext uses the kubeconfig context instead of the current context
+func WithContext(name string) ConnectorOption {
+	return func(overrides *clientcmd.ConfigOverrides) {
+		overrides.CurrentContext = name
+	}
+}
+
+// WithAuthInfo uses the kubeconfig user instead of the user of the context
+func WithAuthInfo(name string) ConnectorOption {
+	return func(overrides *clientcmd.ConfigOverrides) {
+		overrides.Context.AuthInfo = name
+	}
+}
+
+// WithCluster uses the kubeconfig cluster instead of the cluster of the context
+func WithCluster(name string) ConnectorOption {
+	return func(overrides *clientcmd.ConfigOverrides) {
+		overrides.Context.Cluster = name
+	}
+}
+
+// WithNamespace overrides the namespace of the context, it is used when a target has no namespace
+func WithNamespace(namespace string) ConnectorOption {
+	return func(overrides *clientcmd.ConfigOverrides) {
+		overrides.Context.Namespace = namespace
+	}
+}
+
+func newConfigOverrides(opts []ConnectorOption) *clientcmd.ConfigOverrides {
+	overrides := &clientcmd.ConfigOverrides{}
+	for _, opt := range opts {
+		opt(overrides)
+	}
+	return overrides
+}
+
 type KubeConnector struct {
 	masterURL, kubeConfig string
-	restConfig            *rest.Config
+	overrides             *clientcmd.ConfigOverrides
+	namespace             string
 }
 
-func NewKubeConfigConnector(masterURL, kubeConfig string) *KubeConnector {
+func NewKubeConfigConnector(masterURL, kubeConfig string, opts ...ConnectorOption) *KubeConnector {
 	return &KubeConnector{
 		masterURL:  masterURL,
 		kubeConfig: kubeConfig,
+		overrides:  newConfigOverrides(opts),
 	}
 }
 
-func (c *KubeConnector) Connect() (*rest.Config, *kubernetes.Clientset, error) {
-	restCfg, err := parseKubeConfig(
-		c.masterURL, c.kubeConfig,
+func (c *KubeConnector) Connect() (*rest.Config, kubernetes.Interface, error) {
+	clientCfg, err := parseKubeConfig(
+		c.masterURL, c.kubeConfig, c.overrides,
 	)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to get rest config for kubernetes: %w", err)
 	}
 
+	restCfg, k8sClientSet, namespace, err := connectClientConfig(clientCfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	c.namespace = namespace
+	return restCfg, k8sClientSet, nil
+}
+
+// Namespace of the kubeconfig context, known after Connect
+func (c *KubeConnector) Namespace() string {
+	return c.namespace
+}
+
+// LoadingRulesConnector loads kubeconfig the same way kubectl does:
+// from the files listed in $KUBECONFIG merged together or from ~/.kube/config
+type LoadingRulesConnector struct {
+	rules     *clientcmd.ClientConfigLoadingRules
+	overrides *clientcmd.ConfigOverrides
+	namespace string
+}
+
+func NewDefaultConnector(opts ...ConnectorOption) *LoadingRulesConnector {
+	return &LoadingRulesConnector{
+		rules:     clientcmd.NewDefaultClientConfigLoadingRules(),
+		overrides: newConfigOverrides(opts),
+	}
+}
+
+// NewKubeConfigFileConnector loads kubeconfig from the explicit file path only
+func NewKubeConfigFileConnector(path string, opts ...ConnectorOption) *LoadingRulesConnector {
+	return &LoadingRulesConnector{
+		rules:     &clientcmd.ClientConfigLoadingRules{ExplicitPath: path},
+		overrides: newConfigOverrides(opts),
+	}
+}
+
+func (c *LoadingRulesConnector) Connect() (*rest.Config, kubernetes.Interface, error) {
+	restCfg, k8sClientSet, namespace, err := connectClientConfig(
+		clientcmd.NewNonInteractiveDeferredLoadingClientConfig(c.rules, c.overrides),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load kube config: %w", err)
+	}
+
+	c.namespace = namespace
+	return restCfg, k8sClientSet, nil
+}
+
+// Namespace of the kubeconfig context, known after Connect
+func (c *LoadingRulesConnector) Namespace() string {
+	return c.namespace
+}
+
+// InClusterConnector connects with the service account of the pod it is running in
+type InClusterConnector struct {
+	namespace string
+}
+
+func NewInClusterConnector() *InClusterConnector {
+	return &InClusterConnector{}
+}
+
+func (c *InClusterConnector) Connect() (*rest.Config, kubernetes.Interface, error) {
+	restCfg, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get in cluster config: %w", err)
+	}
+
+	if ns, err := os.ReadFile(inClusterNamespaceFile); err == nil {
+		c.namespace = strings.TrimSpace(string(ns))
+	}
+
+	return connectRestConfig(restCfg)
+}
+
+// Namespace of the service account, known after Connect
+func (c *InClusterConnector) Namespace() string {
+	return c.namespace
+}
+
+// ChainConnector tries the connectors in order and uses the first one that succeeds,
+// e.g. in cluster config in CI pods and kubeconfig on laptops
+type ChainConnector struct {
+	connectors []Connector
+	connected  Connector
+}
+
+func NewChainConnector(connectors ...Connector) *ChainConnector {
+	return &ChainConnector{connectors: connectors}
+}
+
+func (c *ChainConnector) Connect() (*rest.Config, kubernetes.Interface, error) {
+	if len(c.connectors) == 0 {
+		return nil, nil, ErrNoConnectorSucceeded
+	}
+
+	errs := make([]error, 0, len(c.connectors))
+	for _, conn := range c.connectors {
+		restCfg, k8sClientSet, err := conn.Connect()
+		if err == nil {
+			c.connected = conn
+			return restCfg, k8sClientSet, nil
+		}
+		errs = append(errs, err)
+	}
+
+	return nil, nil, fmt.Errorf("%w: %w", ErrNoConnectorSucceeded, errors.Join(errs...))
+}
+
+// Namespace of the connector that succeeded, known after Connect
+func (c *ChainConnector) Namespace() string {
+	if nc, ok := c.connected.(namespaceConnector); ok {
+		return nc.Namespace()
+	}
+	return ""
+}
+
+// RestConfigConnector uses an already built rest config and client,
+// e.g. a fake clientset in tests or the config of a controller-runtime manager
+type RestConfigConnector struct {
+	restCfg   *rest.Config
+	clientSet kubernetes.Interface
+	namespace string
+}
+
+// NewRestConfigConnector creates the connector from the rest config and the client,
+// the client is created from the rest config if it is nil,
+// only WithNamespace of the connector options applies, there is no kubeconfig to override
+func NewRestConfigConnector(
+	restCfg *rest.Config,
+	clientSet kubernetes.Interface,
+	opts ...ConnectorOption,
+) *RestConfigConnector {
+	return &RestConfigConnector{
+		restCfg:   restCfg,
+		clientSet: clientSet,
+		namespace: newConfigOverrides(opts).Context.Namespace,
+	}
+}
+
+func (c *RestConfigConnector) Connect() (*rest.Config, kubernetes.Interface, error) {
+	if c.restCfg == nil {
+		return nil, nil, errors.New("rest config cannot be nil")
+	}
+
+	if c.clientSet == nil {
+		return connectRestConfig(c.restCfg)
+	}
+
+	return c.restCfg, c.clientSet, nil
+}
+
+// Namespace set with WithNamespace
+func (c *RestConfigConnector) Namespace() string {
+	return c.namespace
+}
+
+func connectRestConfig(restCfg *rest.Config) (*rest.Config, kubernetes.Interface, error) {
 	k8sClientSet, err := createK8SClientSet(restCfg)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to create kubernetes client set: %w", err)
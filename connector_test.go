@@ -0,0 +1,165 @@
+package portforwarder
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestKubeConfig(t *testing.T, server string) string {
+	t.Helper()
+
+	cfg := clientcmdapi.NewConfig()
+	cfg.Clusters["test"] = &clientcmdapi.Cluster{Server: server}
+	cfg.AuthInfos["test"] = &clientcmdapi.AuthInfo{Token: "token"}
+	cfg.Contexts["test"] = &clientcmdapi.Context{Cluster: "test", AuthInfo: "test"}
+	cfg.Clusters["staging"] = &clientcmdapi.Cluster{Server: "https://staging.example.com"}
+	cfg.AuthInfos["admin"] = &clientcmdapi.AuthInfo{Token: "admin-token"}
+	cfg.Contexts["staging"] = &clientcmdapi.Context{Cluster: "staging", AuthInfo: "test", Namespace: "team-a"}
+	cfg.CurrentContext = "test"
+
+	path := filepath.Join(t.TempDir(), "config")
+	require.NoError(t, clientcmd.WriteToFile(*cfg, path))
+	return path
+}
+
+func TestNewKubeConfigFileConnector(t *testing.T) {
+	path := writeTestKubeConfig(t, "https://127.0.0.1:6443")
+
+	restCfg, clientSet, err := NewKubeConfigFileConnector(path).Connect()
+	require.NoError(t, err)
+	assert.NotNil(t, clientSet)
+	assert.Equal(t, "https://127.0.0.1:6443", restCfg.Host)
+	assert.Equal(t, "token", restCfg.BearerToken)
+}
+
+func TestNewKubeConfigFileConnector_overrides(t *testing.T) {
+	path := writeTestKubeConfig(t, "https://127.0.0.1:6443")
+
+	t.Run("current context", func(t *testing.T) {
+		c := NewKubeConfigFileConnector(path)
+		_, _, err := c.Connect()
+		require.NoError(t, err)
+		assert.Equal(t, "default", c.Namespace())
+	})
+
+	t.Run("context", func(t *testing.T) {
+		c := NewKubeConfigFileConnector(path, WithContext("staging"))
+		restCfg, _, err := c.Connect()
+		require.NoError(t, err)
+		assert.Equal(t, "https://staging.example.com", restCfg.Host)
+		assert.Equal(t, "token", restCfg.BearerToken)
+		assert.Equal(t, "team-a", c.Namespace())
+	})
+
+	t.Run("user, cluster and namespace", func(t *testing.T) {
+		c := NewKubeConfigFileConnector(
+			path,
+			WithAuthInfo("admin"),
+			WithCluster("staging"),
+			WithNamespace("team-b"),
+		)
+		restCfg, _, err := c.Connect()
+		require.NoError(t, err)
+		assert.Equal(t, "https://staging.example.com", restCfg.Host)
+		assert.Equal(t, "admin-token", restCfg.BearerToken)
+		assert.Equal(t, "team-b", c.Namespace())
+	})
+
+	t.Run("unknown context", func(t *testing.T) {
+		_, _, err := NewKubeConfigFileConnector(path, WithContext("prod")).Connect()
+		require.Error(t, err)
+	})
+}
+
+func TestNewDefaultConnector(t *testing.T) {
+	t.Setenv(clientcmd.RecommendedConfigPathEnvVar, writeTestKubeConfig(t, "https://kube.example.com"))
+
+	restCfg, _, err := NewDefaultConnector().Connect()
+	require.NoError(t, err)
+	assert.Equal(t, "https://kube.example.com", restCfg.Host)
+}
+
+func TestNewInClusterConnector(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+	_, _, err := NewInClusterConnector().Connect()
+	require.ErrorIs(t, err, rest.ErrNotInCluster)
+}
+
+func TestNewRestConfigConnector(t *testing.T) {
+	restCfg := &rest.Config{Host: "https://127.0.0.1:6443"}
+
+	t.Run("existing client", func(t *testing.T) {
+		clientSet := fake.NewSimpleClientset()
+		c := NewRestConfigConnector(restCfg, clientSet, WithNamespace("team-a"))
+
+		gotCfg, gotClientSet, err := c.Connect()
+		require.NoError(t, err)
+		assert.Same(t, restCfg, gotCfg)
+		assert.Same(t, clientSet, gotClientSet)
+		assert.Equal(t, "team-a", c.Namespace())
+	})
+
+	t.Run("client is created from the rest config", func(t *testing.T) {
+		_, clientSet, err := NewRestConfigConnector(restCfg, nil).Connect()
+		require.NoError(t, err)
+		assert.IsType(t, &kubernetes.Clientset{}, clientSet)
+	})
+
+	t.Run("nil rest config", func(t *testing.T) {
+		_, _, err := NewRestConfigConnector(nil, fake.NewSimpleClientset()).Connect()
+		require.Error(t, err)
+	})
+}
+
+func TestNewChainConnector(t *testing.T) {
+	t.Run("first successful connector is used", func(t *testing.T) {
+		failing := NewMockConnector(t)
+		failing.EXPECT().Connect().Times(1).Return(nil, nil, errors.New("not in cluster"))
+
+		restCfg := &rest.Config{Host: "https://127.0.0.1:6443"}
+		succeeding := NewMockConnector(t)
+		succeeding.EXPECT().Connect().Times(1).Return(restCfg, &kubernetes.Clientset{}, nil)
+
+		got, _, err := NewChainConnector(failing, succeeding).Connect()
+		require.NoError(t, err)
+		assert.Same(t, restCfg, got)
+	})
+
+	t.Run("namespace of the successful connector", func(t *testing.T) {
+		path := writeTestKubeConfig(t, "https://127.0.0.1:6443")
+		failing := NewMockConnector(t)
+		failing.EXPECT().Connect().Times(1).Return(nil, nil, errors.New("not in cluster"))
+
+		c := NewChainConnector(failing, NewKubeConfigFileConnector(path, WithContext("staging")))
+		_, _, err := c.Connect()
+		require.NoError(t, err)
+		assert.Equal(t, "team-a", c.Namespace())
+	})
+
+	t.Run("all connectors fail", func(t *testing.T) {
+		first := NewMockConnector(t)
+		first.EXPECT().Connect().Times(1).Return(nil, nil, errors.New("not in cluster"))
+		second := NewMockConnector(t)
+		second.EXPECT().Connect().Times(1).Return(nil, nil, errors.New("no kube config"))
+
+		_, _, err := NewChainConnector(first, second).Connect()
+		require.ErrorIs(t, err, ErrNoConnectorSucceeded)
+		assert.Contains(t, err.Error(), "not in cluster")
+		assert.Contains(t, err.Error(), "no kube config")
+	})
+
+	t.Run("no connectors", func(t *testing.T) {
+		_, _, err := NewChainConnector().Connect()
+		require.ErrorIs(t, err, ErrNoConnectorSucceeded)
+		assert.Equal(t, ErrNoConnectorSucceeded.Error(), err.Error())
+	})
+}
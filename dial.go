@@ -0,0 +1,132 @@
+package portforwarder
+
+import (
+	"context"
+	"fmt"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/tools/portforward"
+	"net"
+)
+
+// DialContext opens a stream to the port of the resolved pod and returns it as net.Conn,
+// there is no local listener, so it can be used as the dial function of http.Transport,
+// grpc.WithContextDialer or database drivers. The context is used for resolving and dialing only,
+// the LocalPort, LocalAddresses, LocalSocket, UDP and Reconnect fields of the target are ignored.
+func (pf *PortForwarder) DialContext(
+	ctx context.Context,
+	target *TargetPod,
+	port uint,
+) (net.Conn, error) {
+	target.applyDefaults(pf.namespace())
+	if err := target.validateDial(port); err != nil {
+		return nil, err
+	}
+
+	onEvent := pf.withLogging(target.OnEvent, target.Namespace)
+	onEvent.emit(Resolving{Namespace: target.Namespace})
+	podName, err := pf.resolvePodName(ctx, target)
+	if err != nil {
+		return nil, fmt.Errorf("could not dial a pod: %w", err)
+	}
+	onEvent.emit(PodSelected{Namespace: target.Namespace, Name: podName})
+
+	return pf.dialPod(ctx, target.Namespace, podName, port, onEvent)
+}
+
+func (p *TargetPod) validateDial(port uint) error {
+	if port == 0 {
+		return fmt.Errorf("%w target port is required", ErrTargetPodValidation)
+	}
+
+	if p.Namespace == "" {
+		return fmt.Errorf("%w namespace cannot be empty", ErrTargetPodValidation)
+	}
+
+	if p.Name == "" && len(p.LabelSelector) == 0 {
+		return fmt.Errorf("%w pod name or label selector should be specified", ErrTargetPodValidation)
+	}
+
+	return nil
+}
+
+// dialPod opens a streaming connection to the pod and forwards one end of the in-memory pipe
+// over it the same way the accepted local connections are forwarded
+func (pf *PortForwarder) dialPod(
+	ctx context.Context,
+	namespace, podName string,
+	port uint,
+	onEvent EventHandler,
+) (net.Conn, error) {
+	dialer, err := pf.podDialer(ctx, namespace, podName)
+	if err != nil {
+		return nil, err
+	}
+
+	streamConn, err := dialStream(ctx, dialer)
+	if err != nil {
+		return nil, fmt.Errorf("error upgrading connection to pod %s in namespace %s: %w", podName, namespace, err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		_ = streamConn.Close()
+		return nil, err
+	}
+
+	local, remote := net.Pipe()
+	go func() {
+		defer streamConn.Close()
+		handleConnection(
+			streamConn,
+			&acceptedConn{conn: remote, port: &forwardedPort{remote: port}},
+			requestIDs(streamConn)(),
+			&dialHooks{podName: podName, onEvent: onEvent},
+		)
+	}()
+
+	return local, nil
+}
+
+// dialStream upgrades the streaming connection unless the context is done first,
+// the connection upgraded after that is closed as soon as it arrives
+func dialStream(ctx context.Context, dialer httpstream.Dialer) (httpstream.Connection, error) {
+	type result struct {
+		conn httpstream.Connection
+		err  error
+	}
+
+	resultCh := make(chan result, 1)
+	go func() {
+		conn, _, err := dialer.Dial(portforward.PortForwardProtocolV1Name)
+		resultCh <- result{conn: conn, err: err}
+	}()
+
+	select {
+	case r := <-resultCh:
+		return r.conn, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-resultCh; r.err == nil {
+				_ = r.conn.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// dialHooks report the stream errors of the dialed connections as events
+type dialHooks struct {
+	podName string
+	onEvent EventHandler
+}
+
+func (h *dialHooks) streamError(c *acceptedConn, err error) {
+	h.onEvent.emit(StreamError{
+		PodName:    h.podName,
+		RemotePort: c.port.remotePort(),
+		Err:        err,
+	})
+}
+
+func (h *dialHooks) received(_ *acceptedConn, _ int) {}
+
+func (h *dialHooks) sent(_ *acceptedConn, _ int) {}
@@ -0,0 +1,123 @@
+package portforwarder
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/rest"
+	"testing"
+	"time"
+)
+
+func TestPortForwarder_DialContext(t *testing.T) {
+	ctx := context.TODO()
+	srv := newWebsocketPodServer(t)
+
+	t.Run("stream to the pod port", func(t *testing.T) {
+		pod := readyPod("api-1")
+		pl := newMockPodProvider(t)
+		pl.EXPECT().getPod(ctx, "default", "api-1").Times(1).Return(&pod, nil)
+
+		pf := withCoreClient(t, &PortForwarder{
+			podProvider: pl,
+			restCfg:     &rest.Config{Host: srv.URL, BearerToken: "token"},
+			transport:   TransportWebSocket,
+		})
+
+		recorder := &eventRecorder{}
+		conn, err := pf.DialContext(ctx, &TargetPod{Name: "api-1", OnEvent: recorder.handle}, 8080)
+		require.NoError(t, err)
+		require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+
+		resp := make([]byte, len("8080:ping"))
+		_, err = io.ReadFull(conn, resp)
+		require.NoError(t, err)
+		assert.Equal(t, "8080:ping", string(resp))
+		require.NoError(t, conn.Close())
+
+		assert.Equal(t, []Event{
+			Resolving{Namespace: "default"},
+			PodSelected{Namespace: "default", Name: "api-1"},
+		}, recorder.recorded())
+	})
+
+	t.Run("pod not found", func(t *testing.T) {
+		pl := newMockPodProvider(t)
+		pl.EXPECT().listPods(ctx, &listPodsCommand{
+			namespace:      "default",
+			labelSelectors: map[string]string{"app": "api"},
+		}).Times(1).Return(&v1.PodList{}, nil)
+
+		pf := withCoreClient(t, &PortForwarder{podProvider: pl, restCfg: &rest.Config{Host: srv.URL}})
+
+		_, err := pf.DialContext(ctx, &TargetPod{LabelSelector: map[string]string{"app": "api"}}, 8080)
+		require.ErrorIs(t, err, ErrPodNotFound)
+	})
+
+	t.Run("upgrade failure", func(t *testing.T) {
+		pod := readyPod("api-1")
+		pl := newMockPodProvider(t)
+		pl.EXPECT().getPod(ctx, "default", "api-1").Times(1).Return(&pod, nil)
+
+		pf := withCoreClient(t, &PortForwarder{
+			podProvider: pl,
+			restCfg:     &rest.Config{Host: srv.URL},
+			transport:   TransportWebSocket,
+		})
+
+		_, err := pf.DialContext(ctx, &TargetPod{Name: "api-1"}, 8080)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "401 Unauthorized")
+	})
+
+	t.Run("port is required", func(t *testing.T) {
+		pf := &PortForwarder{restCfg: &rest.Config{}}
+
+		_, err := pf.DialContext(ctx, &TargetPod{Name: "api-1"}, 0)
+		require.ErrorIs(t, err, ErrTargetPodValidation)
+	})
+}
+
+// blockingDialer upgrades the connection once released and hands the upgraded connection over to the test
+type blockingDialer struct {
+	httpstream.Dialer
+	release chan struct{}
+	dialed  chan httpstream.Connection
+}
+
+func (d *blockingDialer) Dial(protocols ...string) (httpstream.Connection, string, error) {
+	<-d.release
+	conn, protocol, err := d.Dialer.Dial(protocols...)
+	if err == nil {
+		d.dialed <- conn
+	}
+	return conn, protocol, err
+}
+
+func Test_dialStream_contextDone(t *testing.T) {
+	dialer := &blockingDialer{
+		Dialer:  &echoPodDialer{},
+		release: make(chan struct{}),
+		dialed:  make(chan httpstream.Connection, 1),
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	_, err := dialStream(ctx, dialer)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	close(dialer.release)
+	late := <-dialer.dialed
+	select {
+	case <-late.CloseChan():
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection upgraded after the context was done is not closed")
+	}
+}
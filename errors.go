@@ -5,4 +5,21 @@ import "errors"
 var (
 	ErrTargetPodValidation = errors.New("target pod validation failed")
 	ErrPodNotFound         = errors.New("could not find pod to forward ports")
+	ErrNoReadyPod          = errors.New("could not find running and ready pod to forward ports")
+	ErrPodPortNotFound     = errors.New("could not resolve pod port")
+
+	ErrLocalPortUnavailable = errors.New("requested local port is not available")
+	ErrLostConnection       = errors.New("lost connection to pod")
+	ErrNotReady             = errors.New("forwarded port is not ready")
+
+	ErrSessionValidation = errors.New("session validation failed")
+
+	ErrNoConnectorSucceeded = errors.New("none of the connectors succeeded")
+
+	ErrTargetServiceValidation = errors.New("target service validation failed")
+	ErrServiceNotFound         = errors.New("could not find service to forward ports")
+	ErrServicePortNotFound     = errors.New("could not resolve service port")
+
+	ErrTargetWorkloadValidation = errors.New("target workload validation failed")
+	ErrWorkloadNotFound         = errors.New("could not find workload to forward ports")
 )
@@ -0,0 +1,113 @@
+package portforwarder
+
+import "sync"
+
+// Event is emitted during the lifecycle of the port forward process,
+// it is one of Resolving, PodSelected, Ready, ConnectionAccepted, StreamError, Reconnecting or Stopped
+type Event interface {
+	isEvent()
+}
+
+// EventHandler is called for every event in the order they are emitted.
+// The events of a port forward process are handled one by one on a goroutine of their own,
+// so the handler may stop the process or the session, but a slow handler delays the rest of the events
+// and Finished, which is closed only after the Stopped event is handled.
+type EventHandler func(Event)
+
+// Resolving is emitted when the pod to forward to is being resolved
+type Resolving struct {
+	Namespace string
+}
+
+// PodSelected is emitted when the pod to forward to is resolved
+type PodSelected struct {
+	Namespace string
+	Name      string
+}
+
+// Ready is emitted every time the connection to the pod is established
+type Ready struct {
+	Namespace string
+	PodName   string
+	// Ports is the mapping of the remote pod ports to the local ports
+	Ports map[uint]uint
+	// Socket is the path of the local Unix socket of the first target port, its local port is 0
+	Socket string
+}
+
+// ConnectionAccepted is emitted for every accepted local connection
+type ConnectionAccepted struct {
+	PodName    string
+	LocalPort  uint
+	RemotePort uint
+	RemoteAddr string
+}
+
+// StreamError is emitted when forwarding of a connection or the connection to the pod fails
+type StreamError struct {
+	PodName    string
+	LocalPort  uint
+	RemotePort uint
+	Err        error
+}
+
+// Reconnecting is emitted before every reconnect attempt
+type Reconnecting struct {
+	Attempt int
+	Err     error
+}
+
+// Stopped is emitted when the process is finished, with the error it was finished with if any
+type Stopped struct {
+	Err error
+}
+
+func (Resolving) isEvent()          {}
+func (PodSelected) isEvent()        {}
+func (Ready) isEvent()              {}
+func (ConnectionAccepted) isEvent() {}
+func (StreamError) isEvent()        {}
+func (Reconnecting) isEvent()       {}
+func (Stopped) isEvent()            {}
+
+func (h EventHandler) emit(e Event) {
+	if h != nil {
+		h(e)
+	}
+}
+
+// eventQueue runs the queued handlers one by one in order on a goroutine of its own,
+// the goroutine is started on demand and exits once the queue is empty
+type eventQueue struct {
+	mx      sync.Mutex
+	pending []func()
+	running bool
+}
+
+func (q *eventQueue) push(f func()) {
+	q.mx.Lock()
+	defer q.mx.Unlock()
+
+	q.pending = append(q.pending, f)
+	if !q.running {
+		q.running = true
+		go q.run()
+	}
+}
+
+func (q *eventQueue) run() {
+	for {
+		q.mx.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.mx.Unlock()
+			return
+		}
+		f := q.pending[0]
+		q.pending[0] = nil
+		q.pending = q.pending[1:]
+		q.mx.Unlock()
+
+		f()
+	}
+}
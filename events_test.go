@@ -0,0 +1,140 @@
+package portforwarder
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/rest"
+	"sync"
+	"testing"
+	"time"
+)
+
+type eventRecorder struct {
+	mx     sync.Mutex
+	events []Event
+}
+
+func (r *eventRecorder) handle(e Event) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *eventRecorder) recorded() []Event {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return append([]Event(nil), r.events...)
+}
+
+func TestPortForwarder_PortForwardAPod_events(t *testing.T) {
+	ctx := context.TODO()
+
+	pl := newMockPodProvider(t)
+	pl.EXPECT().listPods(ctx, mock.Anything).Times(1).
+		Return(&v1.PodList{Items: []v1.Pod{readyPod("api-1")}}, nil)
+	pl.EXPECT().listPods(ctx, mock.Anything).Times(1).
+		Return(&v1.PodList{Items: []v1.Pod{readyPod("api-2")}}, nil)
+
+	lp := newMockListenerProvider(t)
+	lp.EXPECT().listen([]string{"localhost"}, uint(0)).Times(1).Return(newTestListeners(3999), nil)
+
+	f := newMockPortForwarder(t)
+	f.EXPECT().
+		forward(mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		RunAndReturn(func(_ httpstream.Dialer, _ []*forwardedPort, _ <-chan *acceptedConn, _ connHooks, _ <-chan struct{}, readyCh chan struct{}) error {
+			return ErrLostConnection
+		}).
+		Times(1)
+	f.EXPECT().
+		forward(mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		RunAndReturn(forwardUntilStopped).
+		Times(1)
+
+	pf := withCoreClient(t, &PortForwarder{
+		listenerProvider: lp,
+		podProvider:      pl,
+		forwarder:        f,
+		restCfg:          &rest.Config{},
+	})
+
+	recorder := &eventRecorder{}
+	process, err := pf.PortForwardAPod(ctx, &TargetPod{
+		Port:          8080,
+		LabelSelector: map[string]string{"app": "api"},
+		Reconnect:     &ReconnectPolicy{InitialBackoff: time.Millisecond},
+		OnEvent:       recorder.handle,
+	})
+	require.NoError(t, err)
+
+	<-process.Started()
+	process.Stop()
+	<-process.Finished()
+
+	events := recorder.recorded()
+	require.Len(t, events, 8)
+	assert.Equal(t, Resolving{Namespace: "default"}, events[0])
+	assert.Equal(t, PodSelected{Namespace: "default", Name: "api-1"}, events[1])
+	assert.IsType(t, StreamError{}, events[2])
+	assert.IsType(t, Reconnecting{}, events[3])
+	assert.Equal(t, 1, events[3].(Reconnecting).Attempt)
+	assert.Equal(t, Resolving{Namespace: "default"}, events[4])
+	assert.Equal(t, PodSelected{Namespace: "default", Name: "api-2"}, events[5])
+	assert.Equal(t, Ready{Namespace: "default", PodName: "api-2", Ports: map[uint]uint{8080: 3999}}, events[6])
+	assert.Equal(t, Stopped{}, events[7])
+}
+
+func TestPortForwarder_PortForwardAPod_stopFromEventHandler(t *testing.T) {
+	pod := readyPod("api-1")
+	pl := newMockPodProvider(t)
+	pl.EXPECT().getPod(context.TODO(), "default", "api-1").Times(1).Return(&pod, nil)
+
+	f := newMockPortForwarder(t)
+	f.EXPECT().
+		forward(mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(ErrLostConnection).
+		Times(1)
+
+	pf := withCoreClient(t, &PortForwarder{
+		listenerProvider: newNetListenerProvider("tcp"),
+		podProvider:      pl,
+		forwarder:        f,
+		restCfg:          &rest.Config{},
+	})
+
+	processCh := make(chan *PortForwardProcess, 1)
+	stopProcess := func() {
+		p := <-processCh
+		processCh <- p
+		p.Stop()
+	}
+
+	recorder := &eventRecorder{}
+	process, err := pf.PortForwardAPod(context.TODO(), &TargetPod{
+		Name:      "api-1",
+		Port:      8080,
+		Reconnect: &ReconnectPolicy{InitialBackoff: time.Minute},
+		OnEvent: func(e Event) {
+			recorder.handle(e)
+			switch e.(type) {
+			case StreamError, Stopped:
+				stopProcess()
+			}
+		},
+	})
+	require.NoError(t, err)
+	processCh <- process
+
+	select {
+	case <-process.Finished():
+	case <-time.After(5 * time.Second):
+		t.Fatal("process stopped from the event handler did not finish")
+	}
+
+	events := recorder.recorded()
+	assert.IsType(t, StreamError{}, events[2])
+	assert.IsType(t, Stopped{}, events[len(events)-1])
+}
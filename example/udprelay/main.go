@@ -0,0 +1,34 @@
+// udprelay is the relay for the UDP port forwarding, run it in the pod as a sidecar
+// or an ephemeral container next to the UDP service, e.g.
+//
+//	udprelay -listen :5353 -target 127.0.0.1:53
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/denismitr/portforwarder"
+	"log"
+	"net"
+	"os/signal"
+	"syscall"
+)
+
+func main() {
+	listen := flag.String("listen", ":5353", "TCP address the forwarded streams come to")
+	target := flag.String("target", "127.0.0.1:53", "UDP address of the service in the pod")
+	flag.Parse()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("relaying %s to udp %s", l.Addr(), *target)
+	if err := portforwarder.ServeUDPRelay(ctx, l, *target); err != nil {
+		log.Fatal(err)
+	}
+}
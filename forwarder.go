@@ -0,0 +1,184 @@
+package portforwarder
+
+import (
+	"fmt"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/tools/portforward"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// connHooks are notified about the forwarded connections
+type connHooks interface {
+	streamError(c *acceptedConn, err error)
+	// received and sent count the bytes copied from and to the pod
+	received(c *acceptedConn, n int)
+	sent(c *acceptedConn, n int)
+}
+
+// requestIDGenerator is implemented by the streaming connections shared between several forwards,
+// the request id pairs the error and the data streams, so it has to be unique per connection
+type requestIDGenerator interface {
+	nextRequestID() int
+}
+
+// requestIDs returns the generator of the request ids for the streams of the connection
+func requestIDs(streamConn httpstream.Connection) func() int {
+	if g, ok := streamConn.(requestIDGenerator); ok {
+		return g.nextRequestID
+	}
+
+	requestID := -1
+	return func() int {
+		requestID++
+		return requestID
+	}
+}
+
+// streamForwarder forwards the connections accepted on the already bound local listeners
+// over the streaming connection to the pod, so the local ports are never released in between
+type streamForwarder struct{}
+
+func (f *streamForwarder) forward(
+	dialer httpstream.Dialer,
+	ports []*forwardedPort,
+	conns <-chan *acceptedConn,
+	hooks connHooks,
+	stopChan <-chan struct{},
+	readyChan chan struct{},
+) error {
+	streamConn, _, err := dialer.Dial(portforward.PortForwardProtocolV1Name)
+	if err != nil {
+		return fmt.Errorf("error upgrading connection: %w", err)
+	}
+
+	var wg sync.WaitGroup
+	defer func() {
+		_ = streamConn.Close()
+		wg.Wait()
+	}()
+
+	close(readyChan)
+
+	nextRequestID := requestIDs(streamConn)
+	for {
+		select {
+		case <-stopChan:
+			return nil
+		case <-streamConn.CloseChan():
+			return ErrLostConnection
+		case c := <-conns:
+			wg.Add(1)
+			go func(c *acceptedConn, requestID int) {
+				defer wg.Done()
+				handleConnection(streamConn, c, requestID, hooks)
+			}(c, nextRequestID())
+		}
+	}
+}
+
+// handleConnection copies data between the local connection and the data stream to the pod,
+// the same way kubectl port-forward does
+func handleConnection(
+	streamConn httpstream.Connection,
+	c *acceptedConn,
+	requestID int,
+	hooks connHooks,
+) {
+	defer c.done()
+	defer c.conn.Close()
+
+	headers := http.Header{}
+	headers.Set(corev1.StreamType, corev1.StreamTypeError)
+	headers.Set(corev1.PortHeader, strconv.Itoa(int(c.port.remotePort())))
+	headers.Set(corev1.PortForwardRequestIDHeader, strconv.Itoa(requestID))
+	errorStream, err := streamConn.CreateStream(headers)
+	if err != nil {
+		err = fmt.Errorf("error creating error stream for port %s: %w", c.port, err)
+		hooks.streamError(c, err)
+		return
+	}
+	// we're not writing to this stream
+	_ = errorStream.Close()
+	defer streamConn.RemoveStreams(errorStream)
+
+	errorCh := make(chan error)
+	go func() {
+		message, err := io.ReadAll(errorStream)
+		switch {
+		case err != nil:
+			errorCh <- fmt.Errorf("error reading from error stream for port %s: %w", c.port, err)
+		case len(message) > 0:
+			errorCh <- fmt.Errorf("an error occurred forwarding %s: %s", c.port, string(message))
+		}
+		close(errorCh)
+	}()
+
+	headers.Set(corev1.StreamType, corev1.StreamTypeData)
+	dataStream, err := streamConn.CreateStream(headers)
+	if err != nil {
+		err = fmt.Errorf("error creating forwarding stream for port %s: %w", c.port, err)
+		hooks.streamError(c, err)
+		return
+	}
+	defer streamConn.RemoveStreams(dataStream)
+
+	localError := make(chan struct{})
+	remoteDone := make(chan struct{})
+
+	go func() {
+		received := &countingWriter{w: c.conn, count: func(n int) { hooks.received(c, n) }}
+		if _, err := io.Copy(received, dataStream); err != nil && !isClosedConnError(err) {
+			err = fmt.Errorf("error copying from remote stream to local connection: %w", err)
+			hooks.streamError(c, err)
+		}
+		close(remoteDone)
+	}()
+
+	go func() {
+		// inform the pod we're not sending any more data after copy unblocks
+		defer dataStream.Close()
+
+		sent := &countingWriter{w: dataStream, count: func(n int) { hooks.sent(c, n) }}
+		if _, err := io.Copy(sent, c.conn); err != nil && !isClosedConnError(err) {
+			err = fmt.Errorf("error copying from local connection to remote stream: %w", err)
+			hooks.streamError(c, err)
+			close(localError)
+		}
+	}()
+
+	select {
+	case <-remoteDone:
+	case <-localError:
+	}
+
+	// always expect something on errorCh (it may be nil)
+	if err := <-errorCh; err != nil {
+		hooks.streamError(c, err)
+		// the pod refusing the probe is just not ready yet
+		if !c.probe {
+			_ = streamConn.Close()
+		}
+	}
+}
+
+// countingWriter reports the number of the bytes written as they are written
+type countingWriter struct {
+	w     io.Writer
+	count func(n int)
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.count(n)
+	return n, err
+}
+
+func isClosedConnError(err error) bool {
+	return strings.Contains(err.Error(), net.ErrClosed.Error())
+}
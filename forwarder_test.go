@@ -0,0 +1,118 @@
+package portforwarder
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	"k8s.io/client-go/tools/portforward"
+	"net"
+	"testing"
+	"time"
+)
+
+// echoPodDialer dials an in-memory SPDY server, which echoes the data streams back
+// prefixed with the requested port, like a pod would answer through the API server
+type echoPodDialer struct {
+	server httpstream.Connection
+	// handleData - optional handler of the data streams instead of the echo
+	handleData func(s httpstream.Stream)
+}
+
+func (d *echoPodDialer) Dial(_ ...string) (httpstream.Connection, string, error) {
+	clientSide, serverSide := net.Pipe()
+
+	serverCh := make(chan httpstream.Connection, 1)
+	go func() {
+		conn, err := spdy.NewServerConnection(serverSide, func(s httpstream.Stream, _ <-chan struct{}) error {
+			if d.handleData != nil && s.Headers().Get(corev1.StreamType) == corev1.StreamTypeData {
+				go d.handleData(s)
+				return nil
+			}
+
+			go echoStream(s)
+			return nil
+		})
+		if err == nil {
+			serverCh <- conn
+		}
+		close(serverCh)
+	}()
+
+	conn, err := spdy.NewClientConnection(clientSide)
+	if err != nil {
+		return nil, "", err
+	}
+
+	d.server = <-serverCh
+	return conn, portforward.PortForwardProtocolV1Name, nil
+}
+
+func echoStream(s httpstream.Stream) {
+	defer s.Close()
+	if s.Headers().Get(corev1.StreamType) != corev1.StreamTypeData {
+		return
+	}
+
+	_, _ = fmt.Fprintf(s, "%s:", s.Headers().Get(corev1.PortHeader))
+	_, _ = io.Copy(s, s)
+}
+
+func Test_streamForwarder(t *testing.T) {
+	lp := newNetListenerProvider("tcp")
+	listeners, err := lp.listen([]string{"127.0.0.1"}, 0)
+	require.NoError(t, err)
+
+	process := newPortForwardProcess(context.TODO(), []*forwardedPort{newForwardedPort(listeners, 8080)}, nil, nil)
+	dialer := &echoPodDialer{}
+
+	forwardErr := make(chan error, 1)
+	process.wg.Add(1)
+	go func() {
+		defer process.wg.Done()
+		forwardErr <- (&streamForwarder{}).forward(
+			dialer, process.ports, process.connCh, process.hooks(),
+			process.stopCh, process.startedCh,
+		)
+	}()
+
+	select {
+	case <-process.Started():
+	case <-time.After(5 * time.Second):
+		t.Fatal("forwarder did not start")
+	}
+
+	t.Run("connections are forwarded through the bound listener", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", process.Port))
+			require.NoError(t, err)
+
+			_, err = conn.Write([]byte("ping"))
+			require.NoError(t, err)
+			require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+			resp, err := io.ReadAll(conn)
+			require.NoError(t, err)
+			assert.Equal(t, "8080:ping", string(resp))
+			require.NoError(t, conn.Close())
+		}
+	})
+
+	t.Run("lost connection is reported", func(t *testing.T) {
+		require.NoError(t, dialer.server.Close())
+
+		select {
+		case err := <-forwardErr:
+			require.ErrorIs(t, err, ErrLostConnection)
+		case <-time.After(5 * time.Second):
+			t.Fatal("forwarder did not notice the lost connection")
+		}
+	})
+
+	process.Stop()
+	<-process.Finished()
+}
@@ -0,0 +1,22 @@
+// Package grpcdial routes the gRPC connections to the pod port through the port forwarder,
+// it is a separate package, so the port forwarder itself does not depend on gRPC
+//
+//	opt, err := grpcdial.DialOption(pf, &portforwarder.TargetPod{Namespace: "api", Name: "api-0", Port: 9090})
+//	conn, err := grpc.Dial("api", opt, grpc.WithTransportCredentials(insecure.NewCredentials()))
+package grpcdial
+
+import (
+	"github.com/denismitr/portforwarder"
+	"google.golang.org/grpc"
+)
+
+// DialOption returns the dial option which routes every connection through a stream to the target pod port,
+// the pod is resolved again when dialing it fails, the same way PortForwarder.GRPCDialer does
+func DialOption(pf *portforwarder.PortForwarder, target *portforwarder.TargetPod) (grpc.DialOption, error) {
+	dialer, err := pf.GRPCDialer(target)
+	if err != nil {
+		return nil, err
+	}
+
+	return grpc.WithContextDialer(dialer), nil
+}
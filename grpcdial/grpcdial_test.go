@@ -0,0 +1,70 @@
+package grpcdial
+
+import (
+	"context"
+	"github.com/denismitr/portforwarder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestDialOption(t *testing.T) {
+	pod := &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "api-0", Namespace: "default"},
+		Status: v1.PodStatus{
+			Phase:      v1.PodRunning,
+			Conditions: []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}},
+		},
+	}
+
+	// the API server refuses the portforward of the pod
+	portforwards := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case portforwards <- r.URL.Path:
+		default:
+		}
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	t.Cleanup(srv.Close)
+
+	pf, err := portforwarder.NewPortForwarder(
+		portforwarder.NewRestConfigConnector(&rest.Config{Host: srv.URL}, fake.NewSimpleClientset(pod)),
+		portforwarder.WithTransport(portforwarder.TransportWebSocket),
+	)
+	require.NoError(t, err)
+
+	t.Run("connections are dialed through the port forwarder", func(t *testing.T) {
+		opt, err := DialOption(pf, &portforwarder.TargetPod{Name: "api-0", Port: 9090})
+		require.NoError(t, err)
+
+		conn, err := grpc.Dial("api", opt, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		require.NoError(t, err)
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		require.Equal(t, codes.Unavailable, status.Code(err))
+		assert.Contains(t, err.Error(), "403 Forbidden")
+		assert.Equal(t, "/api/v1/namespaces/default/pods/api-0/portforward", <-portforwards)
+	})
+
+	t.Run("invalid target", func(t *testing.T) {
+		_, err := DialOption(pf, &portforwarder.TargetPod{Name: "api-0"})
+		require.ErrorIs(t, err, portforwarder.ErrTargetPodValidation)
+	})
+}
@@ -6,10 +6,9 @@ import (
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/clientcmd"
-	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
-func createK8SClientSet(k8sCfg *rest.Config) (*kubernetes.Clientset, error) {
+func createK8SClientSet(k8sCfg *rest.Config) (kubernetes.Interface, error) {
 	k8sClientSet, err := kubernetes.NewForConfig(k8sCfg)
 	if err != nil {
 		return nil, errors.Wrapf(err, "could not create kubernetes client set")
@@ -18,7 +17,11 @@ func createK8SClientSet(k8sCfg *rest.Config) (*kubernetes.Clientset, error) {
 	return k8sClientSet, nil
 }
 
-func parseKubeConfig(masterURL, config string) (*rest.Config, error) {
+func parseKubeConfig(
+	masterURL,
+	config string,
+	overrides *clientcmd.ConfigOverrides,
+) (clientcmd.ClientConfig, error) {
 	if masterURL == "" {
 		return nil, errors.New("master URL cannot be empty")
 	}
@@ -26,26 +29,46 @@ func parseKubeConfig(masterURL, config string) (*rest.Config, error) {
 	if config == "" {
 		return nil, errors.New("kube config cannot be empty")
 	}
-	
-	k8sCfg, err := clientcmd.BuildConfigFromKubeconfigGetter(
-		masterURL,
-		func() (*clientcmdapi.Config, error) {
-			b, err := base64.StdEncoding.DecodeString(config)
-			if err != nil {
-				return nil, errors.Wrapf(
-					err,
-					"failed to base64 decode config for masterURL %s",
-					masterURL,
-				)
-			}
-
-			return clientcmd.Load(b)
-		},
-	)
 
+	b, err := base64.StdEncoding.DecodeString(config)
+	if err != nil {
+		return nil, errors.Wrapf(
+			err,
+			"failed to base64 decode config for masterURL %s",
+			masterURL,
+		)
+	}
+
+	k8sCfg, err := clientcmd.Load(b)
 	if err != nil {
 		return nil, errors.Wrapf(err, "failed to create kubernetes config")
 	}
 
-	return k8sCfg, nil
+	withMasterURL := *overrides
+	withMasterURL.ClusterInfo.Server = masterURL
+
+	return clientcmd.NewNonInteractiveClientConfig(
+		*k8sCfg, overrides.CurrentContext, &withMasterURL, nil,
+	), nil
+}
+
+// connectClientConfig connects with the client config and resolves
+// the default namespace of its context
+func connectClientConfig(cfg clientcmd.ClientConfig) (*rest.Config, kubernetes.Interface, string, error) {
+	restCfg, err := cfg.ClientConfig()
+	if err != nil {
+		return nil, nil, "", errors.Wrapf(err, "failed to get rest config for kubernetes")
+	}
+
+	namespace, _, err := cfg.Namespace()
+	if err != nil {
+		return nil, nil, "", errors.Wrapf(err, "failed to get namespace of kube config context")
+	}
+
+	k8sClientSet, err := createK8SClientSet(restCfg)
+	if err != nil {
+		return nil, nil, "", errors.Wrapf(err, "failed to create kubernetes client set")
+	}
+
+	return restCfg, k8sClientSet, namespace, nil
 }
@@ -0,0 +1,132 @@
+package portforwarder
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type netListenerProvider struct {
+	network string
+}
+
+func newNetListenerProvider(network string) *netListenerProvider {
+	return &netListenerProvider{network: network}
+}
+
+type listenAddress struct {
+	network, host string
+	// optional address can fail to bind, e.g. ::1 when IPv6 is disabled
+	optional bool
+}
+
+// listen binds the port on all the addresses, when the port is 0 a free one is picked on the first address.
+// The listeners are handed over to the forwarder, so the port cannot be taken by anyone else in between.
+func (p *netListenerProvider) listen(addresses []string, port uint) ([]net.Listener, error) {
+	listeners := make([]net.Listener, 0, len(addresses))
+	for _, addr := range p.resolveAddresses(addresses) {
+		l, err := p.bind(addr.network, addr.address(port))
+		if err != nil {
+			if addr.optional {
+				continue
+			}
+
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+
+			if addr.network == "unix" {
+				return nil, fmt.Errorf("%w: socket %s: %s", ErrLocalPortUnavailable, addr.host, err.Error())
+			}
+
+			if port != 0 {
+				return nil, fmt.Errorf("%w: port %d on %s: %s", ErrLocalPortUnavailable, port, addr.host, err.Error())
+			}
+			return nil, err
+		}
+		listeners = append(listeners, l)
+
+		port = addrPort(l.Addr())
+	}
+
+	if len(listeners) == 0 {
+		return nil, fmt.Errorf("could not listen on any of the addresses %v", addresses)
+	}
+
+	return listeners, nil
+}
+
+// address to bind, the host is the socket path for the Unix sockets
+func (a listenAddress) address(port uint) string {
+	if a.network == "unix" {
+		return a.host
+	}
+	return net.JoinHostPort(a.host, strconv.Itoa(int(port)))
+}
+
+// bind listens on the address, the UDP endpoints are bound as the listeners of the UDP peer streams
+func (p *netListenerProvider) bind(network, address string) (net.Listener, error) {
+	switch {
+	case strings.HasPrefix(network, "udp"):
+		conn, err := net.ListenPacket(network, address)
+		if err != nil {
+			return nil, err
+		}
+		return newUDPListener(conn), nil
+	case network == "unix":
+		removeStaleSocket(address)
+		return net.Listen(network, address)
+	default:
+		return net.Listen(network, address)
+	}
+}
+
+// removeStaleSocket removes the socket file left by a process which did not close its listener,
+// the socket someone still listens on is kept, so binding it fails
+func removeStaleSocket(path string) {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+
+	conn, err := net.Dial("unix", path)
+	if err == nil {
+		_ = conn.Close()
+		return
+	}
+
+	_ = os.Remove(path)
+}
+
+func addrPort(addr net.Addr) uint {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return uint(a.Port)
+	case *net.UDPAddr:
+		return uint(a.Port)
+	default:
+		return 0
+	}
+}
+
+// resolveAddresses expands localhost into both IPv4 and IPv6 loopback addresses,
+// like kubectl port-forward does
+func (p *netListenerProvider) resolveAddresses(addresses []string) []listenAddress {
+	resolved := make([]listenAddress, 0, len(addresses)+1)
+	for _, addr := range addresses {
+		if addr == "localhost" && p.network != "unix" {
+			resolved = append(
+				resolved,
+				listenAddress{network: p.network + "4", host: "127.0.0.1"},
+				listenAddress{network: p.network + "6", host: "::1", optional: true},
+			)
+			continue
+		}
+
+		resolved = append(resolved, listenAddress{network: p.network, host: addr})
+	}
+
+	return resolved
+}
@@ -0,0 +1,99 @@
+package portforwarder
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func Test_netListenerProvider(t *testing.T) {
+	t.Run("localhost on 0 port", func(t *testing.T) {
+		lp := newNetListenerProvider("tcp")
+		listeners, err := lp.listen([]string{"localhost"}, 0)
+		require.NoError(t, err)
+		defer closeTestListeners(listeners)
+
+		port := listeners[0].Addr().(*net.TCPAddr).Port
+		assert.Truef(t, port > 0, "port should be greater than 0, got %d", port)
+		for _, l := range listeners {
+			assert.Equal(t, port, l.Addr().(*net.TCPAddr).Port)
+		}
+	})
+
+	t.Run("listener keeps the port bound", func(t *testing.T) {
+		lp := newNetListenerProvider("tcp")
+		listeners, err := lp.listen([]string{"127.0.0.1"}, 0)
+		require.NoError(t, err)
+		defer closeTestListeners(listeners)
+
+		port := uint(listeners[0].Addr().(*net.TCPAddr).Port)
+		_, err = lp.listen([]string{"127.0.0.1"}, port)
+		require.ErrorIs(t, err, ErrLocalPortUnavailable)
+		assert.Contains(t, err.Error(), strconv.Itoa(int(port)))
+	})
+
+	t.Run("udp endpoints", func(t *testing.T) {
+		lp := newNetListenerProvider("udp")
+		listeners, err := lp.listen([]string{"localhost"}, 0)
+		require.NoError(t, err)
+		defer closeTestListeners(listeners)
+
+		port := listeners[0].Addr().(*net.UDPAddr).Port
+		assert.Truef(t, port > 0, "port should be greater than 0, got %d", port)
+		for _, l := range listeners {
+			assert.Equal(t, port, l.Addr().(*net.UDPAddr).Port)
+		}
+
+		_, err = lp.listen([]string{"127.0.0.1"}, uint(port))
+		require.ErrorIs(t, err, ErrLocalPortUnavailable)
+	})
+}
+
+func Test_netListenerProvider_unix(t *testing.T) {
+	lp := newNetListenerProvider("unix")
+
+	t.Run("socket path", func(t *testing.T) {
+		socket := filepath.Join(t.TempDir(), "api.sock")
+		listeners, err := lp.listen([]string{socket}, 0)
+		require.NoError(t, err)
+		defer closeTestListeners(listeners)
+
+		require.Len(t, listeners, 1)
+		assert.Equal(t, socket, listeners[0].Addr().String())
+
+		_, err = lp.listen([]string{socket}, 0)
+		require.ErrorIs(t, err, ErrLocalPortUnavailable)
+	})
+
+	t.Run("stale socket is replaced", func(t *testing.T) {
+		socket := filepath.Join(t.TempDir(), "api.sock")
+		stale, err := net.Listen("unix", socket)
+		require.NoError(t, err)
+		stale.(*net.UnixListener).SetUnlinkOnClose(false)
+		require.NoError(t, stale.Close())
+		require.FileExists(t, socket)
+
+		listeners, err := lp.listen([]string{socket}, 0)
+		require.NoError(t, err)
+		closeTestListeners(listeners)
+	})
+
+	t.Run("regular file is kept", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "api.sock")
+		require.NoError(t, os.WriteFile(path, []byte("data"), 0o600))
+
+		_, err := lp.listen([]string{path}, 0)
+		require.ErrorIs(t, err, ErrLocalPortUnavailable)
+		assert.FileExists(t, path)
+	})
+}
+
+func closeTestListeners(listeners []net.Listener) {
+	for _, l := range listeners {
+		_ = l.Close()
+	}
+}
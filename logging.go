@@ -0,0 +1,48 @@
+package portforwarder
+
+import "github.com/go-logr/logr"
+
+// withLogging logs every event before passing it to the handler
+func (pf *PortForwarder) withLogging(h EventHandler, namespace string) EventHandler {
+	if pf.logger.GetSink() == nil {
+		return h
+	}
+
+	logger := pf.logger.WithValues("namespace", namespace)
+	return func(e Event) {
+		logEvent(logger, e)
+		h.emit(e)
+	}
+}
+
+func logEvent(logger logr.Logger, e Event) {
+	switch e := e.(type) {
+	case Resolving:
+		logger.V(1).Info("resolving pod")
+	case PodSelected:
+		logger.V(1).Info("pod selected", "pod", e.Name)
+	case Ready:
+		for remote, local := range e.Ports {
+			if local == 0 && e.Socket != "" {
+				logger.Info("forwarding", "pod", e.PodName, "socket", e.Socket, "remotePort", remote)
+				continue
+			}
+			logger.Info("forwarding", "pod", e.PodName, "localPort", local, "remotePort", remote)
+		}
+	case ConnectionAccepted:
+		logger.V(1).Info(
+			"handling connection",
+			"pod", e.PodName, "localPort", e.LocalPort, "remotePort", e.RemotePort, "remoteAddr", e.RemoteAddr,
+		)
+	case StreamError:
+		logger.Error(e.Err, "stream error", "pod", e.PodName, "localPort", e.LocalPort, "remotePort", e.RemotePort)
+	case Reconnecting:
+		logger.Info("reconnecting", "attempt", e.Attempt, "reason", e.Err.Error())
+	case Stopped:
+		if e.Err != nil {
+			logger.Error(e.Err, "port forward stopped")
+			return
+		}
+		logger.Info("port forward stopped")
+	}
+}
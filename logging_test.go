@@ -0,0 +1,39 @@
+package portforwarder
+
+import (
+	"errors"
+	"github.com/go-logr/logr/funcr"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestPortForwarder_withLogging(t *testing.T) {
+	var records []string
+	logger := funcr.New(func(prefix, args string) {
+		records = append(records, args)
+	}, funcr.Options{Verbosity: 1})
+
+	var handled []Event
+	pf := &PortForwarder{logger: logger}
+	onEvent := pf.withLogging(func(e Event) { handled = append(handled, e) }, "kafka-ns")
+
+	onEvent.emit(PodSelected{Namespace: "kafka-ns", Name: "kafka-0"})
+	onEvent.emit(Ready{Namespace: "kafka-ns", PodName: "kafka-0", Ports: map[uint]uint{9092: 4001}})
+	onEvent.emit(StreamError{PodName: "kafka-0", LocalPort: 4001, RemotePort: 9092, Err: errors.New("reset")})
+
+	assert.Len(t, handled, 3)
+	assert.Equal(t, []string{
+		`"level"=1 "msg"="pod selected" "namespace"="kafka-ns" "pod"="kafka-0"`,
+		`"level"=0 "msg"="forwarding" "namespace"="kafka-ns" "pod"="kafka-0" "localPort"=4001 "remotePort"=9092`,
+		`"msg"="stream error" "error"="reset" "namespace"="kafka-ns" "pod"="kafka-0" "localPort"=4001 "remotePort"=9092`,
+	}, records)
+}
+
+func TestPortForwarder_withLogging_withoutLogger(t *testing.T) {
+	var handled []Event
+	pf := &PortForwarder{}
+	onEvent := pf.withLogging(func(e Event) { handled = append(handled, e) }, "default")
+
+	onEvent.emit(Resolving{Namespace: "default"})
+	assert.Equal(t, []Event{Resolving{Namespace: "default"}}, handled)
+}
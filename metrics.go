@@ -0,0 +1,124 @@
+package portforwarder
+
+import (
+	"sync"
+	"time"
+)
+
+// Metrics receives the measurements of the port forward processes,
+// the methods are called from the forwarding goroutines and while handling the events, so they should not block.
+// The pod is the pod being forwarded to at the moment, it changes on reconnects.
+type Metrics interface {
+	// ForwardActive adds delta to the number of the established forwards to the pod port
+	ForwardActive(namespace, pod string, port uint, delta int)
+	// ConnectionAccepted counts the accepted local connections
+	ConnectionAccepted(namespace, pod string, port uint)
+	// BytesReceived counts the bytes received from the pod
+	BytesReceived(namespace, pod string, port uint, n int)
+	// BytesSent counts the bytes sent to the pod
+	BytesSent(namespace, pod string, port uint, n int)
+	// StreamError counts the failed connections, port is 0 when the connection to the pod failed
+	StreamError(namespace, pod string, port uint)
+	// Reconnect counts the reconnect attempts after the connection to the pod was lost
+	Reconnect(namespace, pod string)
+	// TimeToReady observes the time from the start of the process, resolving the pod included, until it is started
+	TimeToReady(namespace, pod string, d time.Duration)
+	// ForwardRemoved is called when the process does not forward to the pod anymore,
+	// after it is stopped or reconnected to another pod, so the active forwards to the pod can be deleted,
+	// the cumulative series should be kept until they are scraped
+	ForwardRemoved(namespace, pod string)
+}
+
+// forwardMetrics reports the measurements of a single process, it is nil without the metrics
+type forwardMetrics struct {
+	metrics   Metrics
+	namespace string
+	startedAt time.Time
+	// mx guards the state derived from the events
+	mx      sync.Mutex
+	ready   bool
+	podName string
+	active  map[uint]string
+}
+
+func (pf *PortForwarder) newForwardMetrics(namespace string) *forwardMetrics {
+	if pf.metrics == nil {
+		return nil
+	}
+
+	return &forwardMetrics{
+		metrics:   pf.metrics,
+		namespace: namespace,
+		startedAt: time.Now(),
+		active:    make(map[uint]string),
+	}
+}
+
+// withMetrics measures the lifecycle events before passing them to the handler
+func (m *forwardMetrics) withMetrics(h EventHandler) EventHandler {
+	if m == nil {
+		return h
+	}
+
+	return func(e Event) {
+		m.observe(e)
+		h.emit(e)
+	}
+}
+
+func (m *forwardMetrics) observe(e Event) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	switch e := e.(type) {
+	case PodSelected:
+		if m.podName != "" && m.podName != e.Name {
+			// reconnected to another pod
+			m.metrics.ForwardRemoved(m.namespace, m.podName)
+		}
+		m.podName = e.Name
+	case Ready:
+		m.podName = e.PodName
+		if !m.ready {
+			m.ready = true
+			m.metrics.TimeToReady(m.namespace, e.PodName, time.Since(m.startedAt))
+		}
+
+		m.deactivate()
+		for remote := range e.Ports {
+			m.active[remote] = e.PodName
+			m.metrics.ForwardActive(m.namespace, e.PodName, remote, 1)
+		}
+	case ConnectionAccepted:
+		m.metrics.ConnectionAccepted(m.namespace, e.PodName, e.RemotePort)
+	case StreamError:
+		m.metrics.StreamError(m.namespace, e.PodName, e.RemotePort)
+	case Reconnecting:
+		m.deactivate()
+		m.metrics.Reconnect(m.namespace, m.podName)
+	case Stopped:
+		m.deactivate()
+		if m.podName != "" {
+			m.metrics.ForwardRemoved(m.namespace, m.podName)
+		}
+	}
+}
+
+func (m *forwardMetrics) deactivate() {
+	for remote, podName := range m.active {
+		m.metrics.ForwardActive(m.namespace, podName, remote, -1)
+		delete(m.active, remote)
+	}
+}
+
+func (m *forwardMetrics) bytesReceived(podName string, port uint, n int) {
+	if m != nil && n > 0 {
+		m.metrics.BytesReceived(m.namespace, podName, port, n)
+	}
+}
+
+func (m *forwardMetrics) bytesSent(podName string, port uint, n int) {
+	if m != nil && n > 0 {
+		m.metrics.BytesSent(m.namespace, podName, port, n)
+	}
+}
@@ -0,0 +1,213 @@
+package portforwarder
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"io"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/rest"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// metricsRecorder sums the measurements by the name and the labels, e.g. "sent default/api-1:8080"
+type metricsRecorder struct {
+	mx          sync.Mutex
+	values      map[string]int
+	timeToReady []string
+	readyAfter  []time.Duration
+	removed     []string
+}
+
+func newMetricsRecorder() *metricsRecorder {
+	return &metricsRecorder{values: make(map[string]int)}
+}
+
+func (r *metricsRecorder) add(name, namespace, pod string, port uint, n int) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.values[fmt.Sprintf("%s %s/%s:%d", name, namespace, pod, port)] += n
+}
+
+func (r *metricsRecorder) get(name, namespace, pod string, port uint) int {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	return r.values[fmt.Sprintf("%s %s/%s:%d", name, namespace, pod, port)]
+}
+
+func (r *metricsRecorder) ForwardActive(namespace, pod string, port uint, delta int) {
+	r.add("active", namespace, pod, port, delta)
+}
+
+func (r *metricsRecorder) ConnectionAccepted(namespace, pod string, port uint) {
+	r.add("accepted", namespace, pod, port, 1)
+}
+
+func (r *metricsRecorder) BytesReceived(namespace, pod string, port uint, n int) {
+	r.add("received", namespace, pod, port, n)
+}
+
+func (r *metricsRecorder) BytesSent(namespace, pod string, port uint, n int) {
+	r.add("sent", namespace, pod, port, n)
+}
+
+func (r *metricsRecorder) StreamError(namespace, pod string, port uint) {
+	r.add("errors", namespace, pod, port, 1)
+}
+
+func (r *metricsRecorder) Reconnect(namespace, pod string) {
+	r.add("reconnects", namespace, pod, 0, 1)
+}
+
+func (r *metricsRecorder) TimeToReady(namespace, pod string, d time.Duration) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.timeToReady = append(r.timeToReady, namespace+"/"+pod)
+	r.readyAfter = append(r.readyAfter, d)
+}
+
+func (r *metricsRecorder) ForwardRemoved(namespace, pod string) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.removed = append(r.removed, namespace+"/"+pod)
+}
+
+func TestPortForwarder_PortForwardAPod_metrics(t *testing.T) {
+	srv := newWebsocketPodServer(t)
+
+	pod := readyPod("api-1")
+	pl := newMockPodProvider(t)
+	// resolving the pod takes a while, it counts to the time to ready
+	pl.EXPECT().getPod(context.TODO(), "default", "api-1").Times(1).Return(&pod, nil).After(50 * time.Millisecond)
+
+	metrics := newMetricsRecorder()
+	pf := withCoreClient(t, &PortForwarder{
+		listenerProvider: newNetListenerProvider("tcp"),
+		podProvider:      pl,
+		forwarder:        &streamForwarder{},
+		restCfg:          &rest.Config{Host: srv.URL, BearerToken: "token"},
+		transport:        TransportWebSocket,
+	})
+	WithMetrics(metrics)(pf)
+
+	process, err := pf.PortForwardAPod(context.TODO(), &TargetPod{
+		Name:           "api-1",
+		Port:           8080,
+		LocalAddresses: []string{"127.0.0.1"},
+	})
+	require.NoError(t, err)
+
+	select {
+	case <-process.Started():
+	case <-time.After(5 * time.Second):
+		t.Fatal("process did not start")
+	}
+	require.Eventually(t, func() bool {
+		return metrics.get("active", "default", "api-1", 8080) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", process.Port))
+		require.NoError(t, err)
+
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+		resp, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		assert.Equal(t, "8080:ping", string(resp))
+		require.NoError(t, conn.Close())
+	}
+
+	process.Stop()
+	<-process.Finished()
+	require.NoError(t, process.Err())
+
+	assert.Equal(t, 0, metrics.get("active", "default", "api-1", 8080))
+	assert.Equal(t, 2, metrics.get("accepted", "default", "api-1", 8080))
+	assert.Equal(t, 2*len("ping"), metrics.get("sent", "default", "api-1", 8080))
+	assert.Equal(t, 2*len("8080:ping"), metrics.get("received", "default", "api-1", 8080))
+	assert.Equal(t, 0, metrics.get("errors", "default", "api-1", 8080))
+	assert.Equal(t, []string{"default/api-1"}, metrics.timeToReady)
+	assert.GreaterOrEqual(t, metrics.readyAfter[0], 50*time.Millisecond)
+	assert.Equal(t, []string{"default/api-1"}, metrics.removed)
+}
+
+func TestPortForwarder_PortForwardAPod_metricsReconnect(t *testing.T) {
+	ctx := context.TODO()
+
+	pl := newMockPodProvider(t)
+	pl.EXPECT().listPods(ctx, mock.Anything).Times(1).
+		Return(&v1.PodList{Items: []v1.Pod{readyPod("api-1")}}, nil)
+	pl.EXPECT().listPods(ctx, mock.Anything).Times(1).
+		Return(&v1.PodList{Items: []v1.Pod{readyPod("api-2")}}, nil)
+
+	lostCh := make(chan struct{})
+	f := newMockPortForwarder(t)
+	f.EXPECT().
+		forward(mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		RunAndReturn(func(_ httpstream.Dialer, _ []*forwardedPort, _ <-chan *acceptedConn, _ connHooks, _ <-chan struct{}, readyCh chan struct{}) error {
+			close(readyCh)
+			<-lostCh
+			return ErrLostConnection
+		}).
+		Times(1)
+	f.EXPECT().
+		forward(mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		RunAndReturn(forwardUntilStopped).
+		Times(1)
+
+	metrics := newMetricsRecorder()
+	pf := withCoreClient(t, &PortForwarder{
+		listenerProvider: newNetListenerProvider("tcp"),
+		podProvider:      pl,
+		forwarder:        f,
+		restCfg:          &rest.Config{},
+		metrics:          metrics,
+	})
+
+	recorder := &eventRecorder{}
+	process, err := pf.PortForwardAPod(ctx, &TargetPod{
+		Namespace:     "shop",
+		Port:          8080,
+		LabelSelector: map[string]string{"app": "api"},
+		Reconnect:     &ReconnectPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond},
+		OnEvent:       recorder.handle,
+	})
+	require.NoError(t, err)
+
+	<-process.Started()
+	require.Eventually(t, func() bool {
+		return metrics.get("active", "shop", "api-1", 8080) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	close(lostCh)
+
+	require.Eventually(t, func() bool {
+		return metrics.get("active", "shop", "api-2", 8080) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 0, metrics.get("active", "shop", "api-1", 8080))
+	assert.Equal(t, 1, metrics.get("reconnects", "shop", "api-1", 0))
+	assert.Equal(t, 1, metrics.get("errors", "shop", "api-1", 0))
+	assert.Equal(t, []string{"shop/api-1"}, metrics.removed, "the series of the previous pod are stale")
+
+	process.Stop()
+	<-process.Finished()
+	assert.Equal(t, 0, metrics.get("active", "shop", "api-2", 8080))
+	assert.Equal(t, []string{"shop/api-1"}, metrics.timeToReady)
+	assert.Equal(t, []string{"shop/api-1", "shop/api-2"}, metrics.removed)
+
+	var ready int
+	for _, e := range recorder.recorded() {
+		if _, ok := e.(Ready); ok {
+			ready++
+		}
+	}
+	assert.Equal(t, 2, ready, "the events are passed to the handler")
+}
@@ -0,0 +1,92 @@
+// Code generated by mockery v2.20.2. DO NOT EDIT.
+
+package portforwarder
+
+import (
+	net "net"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// mockListenerProvider is an autogenerated mock type for the listenerProvider type
+type mockListenerProvider struct {
+	mock.Mock
+}
+
+type mockListenerProvider_Expecter struct {
+	mock *mock.Mock
+}
+
+func (_m *mockListenerProvider) EXPECT() *mockListenerProvider_Expecter {
+	return &mockListenerProvider_Expecter{mock: &_m.Mock}
+}
+
+// listen provides a mock function with given fields: addresses, port
+func (_m *mockListenerProvider) listen(addresses []string, port uint) ([]net.Listener, error) {
+	ret := _m.Called(addresses, port)
+
+	var r0 []net.Listener
+	var r1 error
+	if rf, ok := ret.Get(0).(func([]string, uint) ([]net.Listener, error)); ok {
+		return rf(addresses, port)
+	}
+	if rf, ok := ret.Get(0).(func([]string, uint) []net.Listener); ok {
+		r0 = rf(addresses, port)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]net.Listener)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func([]string, uint) error); ok {
+		r1 = rf(addresses, port)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// mockListenerProvider_listen_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'listen'
+type mockListenerProvider_listen_Call struct {
+	*mock.Call
+}
+
+// listen is a helper method to define mock.On call
+//   - addresses []string
+//   - port uint
+func (_e *mockListenerProvider_Expecter) listen(addresses interface{}, port interface{}) *mockListenerProvider_listen_Call {
+	return &mockListenerProvider_listen_Call{Call: _e.mock.On("listen", addresses, port)}
+}
+
+func (_c *mockListenerProvider_listen_Call) Run(run func(addresses []string, port uint)) *mockListenerProvider_listen_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].([]string), args[1].(uint))
+	})
+	return _c
+}
+
+func (_c *mockListenerProvider_listen_Call) Return(_a0 []net.Listener, _a1 error) *mockListenerProvider_listen_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *mockListenerProvider_listen_Call) RunAndReturn(run func([]string, uint) ([]net.Listener, error)) *mockListenerProvider_listen_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+type mockConstructorTestingTnewMockListenerProvider interface {
+	mock.TestingT
+	Cleanup(func())
+}
+
+// newMockListenerProvider creates a new instance of mockListenerProvider. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+func newMockListenerProvider(t mockConstructorTestingTnewMockListenerProvider) *mockListenerProvider {
+	mock := &mockListenerProvider{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -3,11 +3,8 @@
 package portforwarder
 
 import (
-	io "io"
-
-	httpstream "k8s.io/apimachinery/pkg/util/httpstream"
-
 	mock "github.com/stretchr/testify/mock"
+	httpstream "k8s.io/apimachinery/pkg/util/httpstream"
 )
 
 // mockPortForwarder is an autogenerated mock type for the portForwarder type
@@ -23,13 +20,13 @@ func (_m *mockPortForwarder) EXPECT() *mockPortForwarder_Expecter {
 	return &mockPortForwarder_Expecter{mock: &_m.Mock}
 }
 
-// forward provides a mock function with given fields: dialer, ports, stopChan, readyChan, out, errOut
-func (_m *mockPortForwarder) forward(dialer httpstream.Dialer, ports []string, stopChan <-chan struct{}, readyChan chan struct{}, out io.Writer, errOut io.Writer) error {
-	ret := _m.Called(dialer, ports, stopChan, readyChan, out, errOut)
+// forward provides a mock function with given fields: dialer, ports, conns, hooks, stopChan, readyChan
+func (_m *mockPortForwarder) forward(dialer httpstream.Dialer, ports []*forwardedPort, conns <-chan *acceptedConn, hooks connHooks, stopChan <-chan struct{}, readyChan chan struct{}) error {
+	ret := _m.Called(dialer, ports, conns, hooks, stopChan, readyChan)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(httpstream.Dialer, []string, <-chan struct{}, chan struct{}, io.Writer, io.Writer) error); ok {
-		r0 = rf(dialer, ports, stopChan, readyChan, out, errOut)
+	if rf, ok := ret.Get(0).(func(httpstream.Dialer, []*forwardedPort, <-chan *acceptedConn, connHooks, <-chan struct{}, chan struct{}) error); ok {
+		r0 = rf(dialer, ports, conns, hooks, stopChan, readyChan)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -44,18 +41,18 @@ type mockPortForwarder_forward_Call struct {
 
 // forward is a helper method to define mock.On call
 //   - dialer httpstream.Dialer
-//   - ports []string
+//   - ports []*forwardedPort
+//   - conns <-chan *acceptedConn
+//   - hooks connHooks
 //   - stopChan <-chan struct{}
 //   - readyChan chan struct{}
-//   - out io.Writer
-//   - errOut io.Writer
-func (_e *mockPortForwarder_Expecter) forward(dialer interface{}, ports interface{}, stopChan interface{}, readyChan interface{}, out interface{}, errOut interface{}) *mockPortForwarder_forward_Call {
-	return &mockPortForwarder_forward_Call{Call: _e.mock.On("forward", dialer, ports, stopChan, readyChan, out, errOut)}
+func (_e *mockPortForwarder_Expecter) forward(dialer interface{}, ports interface{}, conns interface{}, hooks interface{}, stopChan interface{}, readyChan interface{}) *mockPortForwarder_forward_Call {
+	return &mockPortForwarder_forward_Call{Call: _e.mock.On("forward", dialer, ports, conns, hooks, stopChan, readyChan)}
 }
 
-func (_c *mockPortForwarder_forward_Call) Run(run func(dialer httpstream.Dialer, ports []string, stopChan <-chan struct{}, readyChan chan struct{}, out io.Writer, errOut io.Writer)) *mockPortForwarder_forward_Call {
+func (_c *mockPortForwarder_forward_Call) Run(run func(dialer httpstream.Dialer, ports []*forwardedPort, conns <-chan *acceptedConn, hooks connHooks, stopChan <-chan struct{}, readyChan chan struct{})) *mockPortForwarder_forward_Call {
 	_c.Call.Run(func(args mock.Arguments) {
-		run(args[0].(httpstream.Dialer), args[1].([]string), args[2].(<-chan struct{}), args[3].(chan struct{}), args[4].(io.Writer), args[5].(io.Writer))
+		run(args[0].(httpstream.Dialer), args[1].([]*forwardedPort), args[2].(<-chan *acceptedConn), args[3].(connHooks), args[4].(<-chan struct{}), args[5].(chan struct{}))
 	})
 	return _c
 }
@@ -65,7 +62,7 @@ func (_c *mockPortForwarder_forward_Call) Return(_a0 error) *mockPortForwarder_f
 	return _c
 }
 
-func (_c *mockPortForwarder_forward_Call) RunAndReturn(run func(httpstream.Dialer, []string, <-chan struct{}, chan struct{}, io.Writer, io.Writer) error) *mockPortForwarder_forward_Call {
+func (_c *mockPortForwarder_forward_Call) RunAndReturn(run func(httpstream.Dialer, []*forwardedPort, <-chan *acceptedConn, connHooks, <-chan struct{}, chan struct{}) error) *mockPortForwarder_forward_Call {
 	_c.Call.Return(run)
 	return _c
 }
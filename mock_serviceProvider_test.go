@@ -0,0 +1,94 @@
+// Code generated by mockery v2.20.2. DO NOT EDIT.
+
+package portforwarder
+
+import (
+	context "context"
+
+	mock "github.com/stretchr/testify/mock"
+	v1 "k8s.io/api/core/v1"
+)
+
+// mockServiceProvider is an autogenerated mock type for the serviceProvider type
+type mockServiceProvider struct {
+	mock.Mock
+}
+
+type mockServiceProvider_Expecter struct {
+	mock *mock.Mock
+}
+
+func (_m *mockServiceProvider) EXPECT() *mockServiceProvider_Expecter {
+	return &mockServiceProvider_Expecter{mock: &_m.Mock}
+}
+
+// getService provides a mock function with given fields: ctx, namespace, name
+func (_m *mockServiceProvider) getService(ctx context.Context, namespace string, name string) (*v1.Service, error) {
+	ret := _m.Called(ctx, namespace, name)
+
+	var r0 *v1.Service
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) (*v1.Service, error)); ok {
+		return rf(ctx, namespace, name)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) *v1.Service); ok {
+		r0 = rf(ctx, namespace, name)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*v1.Service)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string) error); ok {
+		r1 = rf(ctx, namespace, name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// mockServiceProvider_getService_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'getService'
+type mockServiceProvider_getService_Call struct {
+	*mock.Call
+}
+
+// getService is a helper method to define mock.On call
+//   - ctx context.Context
+//   - namespace string
+//   - name string
+func (_e *mockServiceProvider_Expecter) getService(ctx interface{}, namespace interface{}, name interface{}) *mockServiceProvider_getService_Call {
+	return &mockServiceProvider_getService_Call{Call: _e.mock.On("getService", ctx, namespace, name)}
+}
+
+func (_c *mockServiceProvider_getService_Call) Run(run func(ctx context.Context, namespace string, name string)) *mockServiceProvider_getService_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(string), args[2].(string))
+	})
+	return _c
+}
+
+func (_c *mockServiceProvider_getService_Call) Return(_a0 *v1.Service, _a1 error) *mockServiceProvider_getService_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *mockServiceProvider_getService_Call) RunAndReturn(run func(context.Context, string, string) (*v1.Service, error)) *mockServiceProvider_getService_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+type mockConstructorTestingTnewMockServiceProvider interface {
+	mock.TestingT
+	Cleanup(func())
+}
+
+// newMockServiceProvider creates a new instance of mockServiceProvider. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+func newMockServiceProvider(t mockConstructorTestingTnewMockServiceProvider) *mockServiceProvider {
+	mock := &mockServiceProvider{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
@@ -0,0 +1,94 @@
+// Code generated by mockery v2.20.2. DO NOT EDIT.
+
+package portforwarder
+
+import (
+	context "context"
+
+	mock "github.com/stretchr/testify/mock"
+)
+
+// mockWorkloadProvider is an autogenerated mock type for the workloadProvider type
+type mockWorkloadProvider struct {
+	mock.Mock
+}
+
+type mockWorkloadProvider_Expecter struct {
+	mock *mock.Mock
+}
+
+func (_m *mockWorkloadProvider) EXPECT() *mockWorkloadProvider_Expecter {
+	return &mockWorkloadProvider_Expecter{mock: &_m.Mock}
+}
+
+// getWorkloadSelector provides a mock function with given fields: ctx, kind, namespace, name
+func (_m *mockWorkloadProvider) getWorkloadSelector(ctx context.Context, kind WorkloadKind, namespace string, name string) (*workloadSelector, error) {
+	ret := _m.Called(ctx, kind, namespace, name)
+
+	var r0 *workloadSelector
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, WorkloadKind, string, string) (*workloadSelector, error)); ok {
+		return rf(ctx, kind, namespace, name)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, WorkloadKind, string, string) *workloadSelector); ok {
+		r0 = rf(ctx, kind, namespace, name)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*workloadSelector)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, WorkloadKind, string, string) error); ok {
+		r1 = rf(ctx, kind, namespace, name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// mockWorkloadProvider_getWorkloadSelector_Call is a *mock.Call that shadows Run/Return methods with type explicit version for method 'getWorkloadSelector'
+type mockWorkloadProvider_getWorkloadSelector_Call struct {
+	*mock.Call
+}
+
+// getWorkloadSelector is a helper method to define mock.On call
+//   - ctx context.Context
+//   - kind WorkloadKind
+//   - namespace string
+//   - name string
+func (_e *mockWorkloadProvider_Expecter) getWorkloadSelector(ctx interface{}, kind interface{}, namespace interface{}, name interface{}) *mockWorkloadProvider_getWorkloadSelector_Call {
+	return &mockWorkloadProvider_getWorkloadSelector_Call{Call: _e.mock.On("getWorkloadSelector", ctx, kind, namespace, name)}
+}
+
+func (_c *mockWorkloadProvider_getWorkloadSelector_Call) Run(run func(ctx context.Context, kind WorkloadKind, namespace string, name string)) *mockWorkloadProvider_getWorkloadSelector_Call {
+	_c.Call.Run(func(args mock.Arguments) {
+		run(args[0].(context.Context), args[1].(WorkloadKind), args[2].(string), args[3].(string))
+	})
+	return _c
+}
+
+func (_c *mockWorkloadProvider_getWorkloadSelector_Call) Return(_a0 *workloadSelector, _a1 error) *mockWorkloadProvider_getWorkloadSelector_Call {
+	_c.Call.Return(_a0, _a1)
+	return _c
+}
+
+func (_c *mockWorkloadProvider_getWorkloadSelector_Call) RunAndReturn(run func(context.Context, WorkloadKind, string, string) (*workloadSelector, error)) *mockWorkloadProvider_getWorkloadSelector_Call {
+	_c.Call.Return(run)
+	return _c
+}
+
+type mockConstructorTestingTnewMockWorkloadProvider interface {
+	mock.TestingT
+	Cleanup(func())
+}
+
+// newMockWorkloadProvider creates a new instance of mockWorkloadProvider. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
+func newMockWorkloadProvider(t mockConstructorTestingTnewMockWorkloadProvider) *mockWorkloadProvider {
+	mock := &mockWorkloadProvider{}
+	mock.Mock.Test(t)
+
+	t.Cleanup(func() { mock.AssertExpectations(t) })
+
+	return mock
+}
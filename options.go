@@ -0,0 +1,50 @@
+package portforwarder
+
+import (
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Option configures the PortForwarder
+type Option func(pf *PortForwarder)
+
+// WithLogger routes the lifecycle of the port forward processes to the logger
+// as structured records, nothing is logged by default
+func WithLogger(logger logr.Logger) Option {
+	return func(pf *PortForwarder) {
+		pf.logger = logger
+	}
+}
+
+// WithTransport sets the protocol of the streaming connection to the API server, SPDY by default
+func WithTransport(transport Transport) Option {
+	return func(pf *PortForwarder) {
+		pf.transport = transport
+	}
+}
+
+// WithConnectionPooling shares one streaming connection per pod between all the forwards
+// and the dialed connections to it, instead of upgrading a new connection to the API server for each of them.
+// The connection is closed when the last of its users is stopped or closed.
+func WithConnectionPooling() Option {
+	return func(pf *PortForwarder) {
+		pf.connPool = newConnPool()
+	}
+}
+
+// WithMetrics reports the active forwards, the accepted connections, the transferred bytes, the stream errors,
+// the reconnects and the time to ready of every port forward process to the metrics
+func WithMetrics(metrics Metrics) Option {
+	return func(pf *PortForwarder) {
+		pf.metrics = metrics
+	}
+}
+
+// WithTracerProvider traces resolving of the pods, upgrading of the streaming connections,
+// the readiness probes and the forwarded connections as the children of the span of the context
+// the port forward was started with, nothing is traced by default
+func WithTracerProvider(tp trace.TracerProvider) Option {
+	return func(pf *PortForwarder) {
+		pf.tracerProvider = tp
+	}
+}
@@ -3,22 +3,23 @@ package portforwarder
 import (
 	"context"
 	"fmt"
-	"io"
+	"github.com/go-logr/logr"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
+	corev1client "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
-	"k8s.io/client-go/tools/portforward"
-	"k8s.io/client-go/transport/spdy"
-	"net/http"
+	"net"
 	"net/url"
-	"os"
-	"strings"
+	"time"
 )
 
-//go:generate go run github.com/vektra/mockery/v2@v2.20.2 --name freePortProvider
-type freePortProvider interface {
-	getFreePort() (uint, error)
+//go:generate go run github.com/vektra/mockery/v2@v2.20.2 --name listenerProvider
+type listenerProvider interface {
+	listen(addresses []string, port uint) ([]net.Listener, error)
 }
 
 //go:generate go run github.com/vektra/mockery/v2@v2.20.2 --name podProvider
@@ -27,69 +28,166 @@ type podProvider interface {
 	getPod(ctx context.Context, namespace, name string) (*corev1.Pod, error)
 }
 
+//go:generate go run github.com/vektra/mockery/v2@v2.20.2 --name serviceProvider
+type serviceProvider interface {
+	getService(ctx context.Context, namespace, name string) (*corev1.Service, error)
+}
+
+//go:generate go run github.com/vektra/mockery/v2@v2.20.2 --name workloadProvider
+type workloadProvider interface {
+	getWorkloadSelector(ctx context.Context, kind WorkloadKind, namespace, name string) (*workloadSelector, error)
+}
+
 //go:generate go run github.com/vektra/mockery/v2@v2.20.2 --name portForwarder
 type portForwarder interface {
 	forward(
 		dialer httpstream.Dialer,
-		ports []string,
+		ports []*forwardedPort,
+		conns <-chan *acceptedConn,
+		hooks connHooks,
 		stopChan <-chan struct{},
 		readyChan chan struct{},
-		out,
-		errOut io.Writer,
 	) error
 }
 
-//go:generate go run github.com/vektra/mockery/v2@v2.20.2 --name connector
-type connector interface {
-	Connect() (*rest.Config, *kubernetes.Clientset, error)
+// Connector provides the rest config and the client of the cluster, e.g. KubeConnector or ChainConnector
+//
+//go:generate go run github.com/vektra/mockery/v2@v2.20.2 --name Connector
+type Connector interface {
+	Connect() (*rest.Config, kubernetes.Interface, error)
 }
 
 type PortForwarder struct {
-	restCfg          *rest.Config
-	freePortProvider freePortProvider
-	forwarder        portForwarder
-	podProvider      podProvider
+	logger  logr.Logger
+	restCfg *rest.Config
+	// coreClient composes the portforward URLs of the pods, it is built once by NewPortForwarder
+	coreClient       rest.Interface
+	defaultNamespace string
+	transport        Transport
+	// metrics - optional receiver of the measurements of the processes
+	metrics Metrics
+	// tracerProvider - optional provider of the tracer of the phases
+	tracerProvider trace.TracerProvider
+	// connPool - optional pool of the streaming connections shared per pod
+	connPool         *connPool
+	listenerProvider listenerProvider
+	// packetListenerProvider binds the local UDP endpoints of the UDP targets
+	packetListenerProvider listenerProvider
+	// socketListenerProvider binds the local Unix sockets
+	socketListenerProvider listenerProvider
+	forwarder              portForwarder
+	podProvider            podProvider
+	serviceProvider        serviceProvider
+	workloadProvider       workloadProvider
 }
 
-func NewPortForwarder(conn connector) (*PortForwarder, error) {
+func NewPortForwarder(conn Connector, opts ...Option) (*PortForwarder, error) {
 	restCfg, k8sClientSet, err := conn.Connect()
 	if err != nil {
 		return nil, err
 	}
 
-	fpp := newNetFreePortProvider("tcp", "localhost", 0)
+	coreClient, err := newCoreRESTClient(restCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	lp := newNetListenerProvider("tcp")
 	s := newSelectorFromKubeConfig(k8sClientSet)
 
-	return &PortForwarder{
-		restCfg:          restCfg,
-		freePortProvider: fpp,
-		podProvider:      s,
-		forwarder:        &spdyForwarder{},
-	}, nil
+	pf := &PortForwarder{
+		logger:                 logr.Discard(),
+		restCfg:                restCfg,
+		coreClient:             coreClient,
+		listenerProvider:       lp,
+		packetListenerProvider: newNetListenerProvider("udp"),
+		socketListenerProvider: newNetListenerProvider("unix"),
+		podProvider:            s,
+		serviceProvider:        s,
+		workloadProvider:       s,
+		forwarder:              &streamForwarder{},
+	}
+
+	if nc, ok := conn.(namespaceConnector); ok {
+		pf.defaultNamespace = nc.Namespace()
+	}
+
+	for _, opt := range opts {
+		opt(pf)
+	}
+
+	return pf, nil
+}
+
+// namespace is used for the targets without namespace,
+// it is the namespace of the kubeconfig context if the connector knows it
+func (pf *PortForwarder) namespace() string {
+	if pf.defaultNamespace == "" {
+		return "default"
+	}
+	return pf.defaultNamespace
 }
 
 type TargetPod struct {
 	// Port of the pod in k8s
 	Port uint
+	// Ports - optional additional ports of the pod, numeric or named container ports,
+	// all of them are forwarded by the same process
+	Ports []intstr.IntOrString
 	// Name - optional pod name, to specify the exact pod name if known
 	Name string
-	// Namespace to look for the suitable pod to forward
+	// Namespace to look for the suitable pod to forward, defaults to the namespace of the kubeconfig context
 	Namespace string
 	// LabelSelector to match the suitable pod to forward
 	LabelSelector map[string]string
+	// PodSelectionStrategy - optional strategy to pick one of the ready pods
+	// matching the label selector, the first ready pod is picked by default
+	PodSelectionStrategy PodSelectionStrategy
+	// LocalPort - optional local port to forward the first target port to,
+	// free local ports are picked when not specified
+	LocalPort uint
+	// LocalAddresses - optional local addresses to listen on, e.g. 0.0.0.0, localhost by default
+	LocalAddresses []string
+	// LocalSocket - optional path of the local Unix socket to forward the first target port to
+	// instead of the local port, e.g. /tmp/.s.PGSQL.5432
+	LocalSocket string
+	// Reconnect - optional policy to reconnect when the pod restarts or the connection drops
+	Reconnect *ReconnectPolicy
+	// OnEvent - optional handler of the lifecycle events
+	OnEvent EventHandler
+	// UDP - optional, binds the local ports as UDP endpoints and forwards the datagrams
+	// to the UDP relay in the pod listening on the target ports, see ServeUDPRelay
+	UDP bool
+	// Readiness - optional probe of the first target port through the tunnel,
+	// Started is signalled only after it passes
+	Readiness *ReadinessProbe
 }
 
-func (p *TargetPod) applyDefaults() {
+func (p *TargetPod) applyDefaults(namespace string) {
 	if p.Namespace == "" {
-		p.Namespace = "default"
+		p.Namespace = namespace
+	}
+
+	if len(p.LocalAddresses) == 0 {
+		p.LocalAddresses = defaultLocalAddresses()
 	}
 }
 
+func defaultLocalAddresses() []string {
+	return []string{"localhost"}
+}
+
 func (p *TargetPod) validate() error {
-	if p.Port == 0 {
+	if p.Port == 0 && len(p.Ports) == 0 {
 		return fmt.Errorf("%w target port is required", ErrTargetPodValidation)
 	}
 
+	for _, port := range p.Ports {
+		if port.Type == intstr.Int && port.IntVal <= 0 || port.Type == intstr.String && port.StrVal == "" {
+			return fmt.Errorf("%w invalid target port %s", ErrTargetPodValidation, port.String())
+		}
+	}
+
 	if p.Namespace == "" {
 		return fmt.Errorf("%w namespace cannot be empty", ErrTargetPodValidation)
 	}
@@ -98,6 +196,14 @@ func (p *TargetPod) validate() error {
 		return fmt.Errorf("%w pod name or label selector should be specified", ErrTargetPodValidation)
 	}
 
+	if p.LocalSocket != "" && (p.LocalPort != 0 || p.UDP) {
+		return fmt.Errorf("%w local socket cannot be combined with local port or UDP", ErrTargetPodValidation)
+	}
+
+	if p.UDP && p.Readiness != nil && p.Readiness.Func == nil {
+		return fmt.Errorf("%w only the custom readiness probe can check UDP", ErrTargetPodValidation)
+	}
+
 	return nil
 }
 
@@ -105,22 +211,105 @@ func (pf *PortForwarder) PortForwardAPod(
 	ctx context.Context,
 	target *TargetPod,
 ) (*PortForwardProcess, error) {
-	target.applyDefaults()
+	target.applyDefaults(pf.namespace())
 	if err := target.validate(); err != nil {
 		return nil, err
 	}
 
-	freePort, err := pf.freePortProvider.getFreePort()
+	// the time to ready is measured from here, so it includes resolving the pod
+	metrics := pf.newForwardMetrics(target.Namespace)
+	onEvent := metrics.withMetrics(pf.withLogging(target.OnEvent, target.Namespace))
+	onEvent.emit(Resolving{Namespace: target.Namespace})
+	pod, err := pf.resolveTargetPod(ctx, target)
 	if err != nil {
-		return nil, fmt.Errorf("get free port failed: %w", err)
+		return nil, fmt.Errorf("could not port forward a pod: %w", err)
 	}
+	onEvent.emit(PodSelected{Namespace: target.Namespace, Name: pod.GetName()})
 
-	podName, err := getPodName(ctx, pf.podProvider, target)
+	targetPorts, err := resolvePodPorts(pod, target.Port, target.Ports)
 	if err != nil {
-		return nil, fmt.Errorf("could not port forward a pod: %w", err)
+		return nil, err
+	}
+
+	return pf.forwardToPod(ctx, &forwardCommand{
+		namespace:      target.Namespace,
+		podName:        pod.GetName(),
+		targetPorts:    targetPorts,
+		localPort:      target.LocalPort,
+		localAddresses: target.LocalAddresses,
+		localSocket:    target.LocalSocket,
+		reconnect:      target.Reconnect,
+		resolvePod: func(ctx context.Context) (string, []uint, error) {
+			pod, err := pf.resolveTargetPod(ctx, target)
+			if err != nil {
+				return "", nil, err
+			}
+
+			targetPorts, err := resolvePodPorts(pod, target.Port, target.Ports)
+			return pod.GetName(), targetPorts, err
+		},
+		onEvent:   onEvent,
+		metrics:   metrics,
+		udp:       target.UDP,
+		readiness: target.Readiness,
+	})
+}
+
+type forwardCommand struct {
+	namespace   string
+	podName     string
+	targetPorts []uint
+	// localPort for the first target port, 0 to pick a free one
+	localPort      uint
+	localAddresses []string
+	// localSocket for the first target port instead of the local port
+	localSocket string
+	// reconnect is optional, the process stops on the first forwarding error without it
+	reconnect *ReconnectPolicy
+	// resolvePod resolves the target again when reconnecting,
+	// the named ports are resolved against the newly selected pod
+	resolvePod func(ctx context.Context) (podName string, targetPorts []uint, err error)
+	onEvent    EventHandler
+	// metrics is nil without the metrics, onEvent is observed by it already
+	metrics *forwardMetrics
+	// udp binds the local UDP endpoints instead of the TCP listeners
+	udp bool
+	// readiness is optional, it gates the Ready event and the start of the process
+	readiness *ReadinessProbe
+}
+
+// forwardToPod starts forwarding a local port to each of the target ports of the resolved pod
+func (pf *PortForwarder) forwardToPod(
+	ctx context.Context,
+	cmd *forwardCommand,
+) (*PortForwardProcess, error) {
+	lp := pf.listenerProvider
+	if cmd.udp {
+		lp = pf.packetListenerProvider
+	}
+
+	ports := make([]*forwardedPort, 0, len(cmd.targetPorts))
+	for i, targetPort := range cmd.targetPorts {
+		var listeners []net.Listener
+		var err error
+		switch {
+		case i == 0 && cmd.localSocket != "":
+			listeners, err = pf.socketListenerProvider.listen([]string{cmd.localSocket}, 0)
+		case i == 0:
+			listeners, err = lp.listen(cmd.localAddresses, cmd.localPort)
+		default:
+			listeners, err = lp.listen(cmd.localAddresses, 0)
+		}
+		if err != nil {
+			closeListeners(ports)
+			return nil, fmt.Errorf("listen on local port failed: %w", err)
+		}
+
+		ports = append(ports, newForwardedPort(listeners, targetPort))
 	}
 
-	process := newPortForwardProcess(ctx, freePort)
+	process := newPortForwardProcess(ctx, ports, cmd.onEvent, pf.tracer())
+	process.metrics = cmd.metrics
 	process.wg.Add(1)
 	go func(p *PortForwardProcess) {
 		defer func() {
@@ -128,74 +317,202 @@ func (pf *PortForwarder) PortForwardAPod(
 			p.Stop()
 		}()
 
-		if err := pf.portForwardAPod(p, target.Namespace, podName, freePort, target.Port); err != nil {
-			p.setError(fmt.Errorf(
-				"init port forwarder for pod %s in namespace %s failed: %w",
-				podName, target.Namespace, err,
-			))
+		if err := pf.keepForwarding(ctx, p, cmd); err != nil {
+			p.setError(err)
 		}
 	}(process)
 
 	return process, nil
 }
 
+// keepForwarding forwards the ports until the process is stopped,
+// with a reconnect policy the target is resolved again and redialed after the forwarding errors
+func (pf *PortForwarder) keepForwarding(
+	ctx context.Context,
+	process *PortForwardProcess,
+	cmd *forwardCommand,
+) error {
+	podName := cmd.podName
+	attempt := 0
+	for {
+		process.setPodName(podName)
+		fa := process.newForwardAttempt()
+		go process.markAsReadyOn(fa, Ready{
+			Namespace: cmd.namespace,
+			PodName:   podName,
+			Ports:     process.Ports(),
+			Socket:    process.Socket,
+		}, cmd.readiness)
+
+		err := pf.portForwardAPod(ctx, process, cmd.namespace, podName, fa)
+		fa.done()
+		if err == nil {
+			// the attempt is aborted when the probe fails after the process is started
+			err = fa.abortErr()
+		}
+		if err == nil || process.isStopped() {
+			return nil
+		}
+		process.emit(StreamError{PodName: podName, Err: err})
+
+		err = fmt.Errorf(
+			"init port forwarder for pod %s in namespace %s failed: %w",
+			podName, cmd.namespace, err,
+		)
+
+		if isClosed(fa.readyCh) && fa.abortErr() == nil {
+			// the connection was established, so the failures in a row start over
+			attempt = 0
+		}
+
+		for {
+			attempt++
+			if !cmd.reconnect.allows(attempt) {
+				return err
+			}
+
+			process.emit(Reconnecting{Attempt: attempt, Err: err})
+			select {
+			case <-process.stopCh:
+				return nil
+			case <-time.After(cmd.reconnect.backoff(attempt)):
+			}
+
+			process.emit(Resolving{Namespace: cmd.namespace})
+			var targetPorts []uint
+			podName, targetPorts, err = cmd.resolvePod(ctx)
+			if err == nil {
+				err = process.setRemotePorts(podName, targetPorts)
+			}
+			if err == nil {
+				process.emit(PodSelected{Namespace: cmd.namespace, Name: podName})
+				break
+			}
+		}
+	}
+}
+
 func (pf *PortForwarder) portForwardAPod(
+	ctx context.Context,
 	process *PortForwardProcess,
 	namespace,
 	podName string,
-	freePort uint,
-	targetPort uint,
+	attempt *forwardAttempt,
 ) error {
-	errCh := make(chan error, 1)
-
-	roundTripper, upgrader, err := spdy.RoundTripperFor(pf.restCfg)
+	dialer, err := pf.podDialer(ctx, namespace, podName)
 	if err != nil {
 		return err
 	}
 
-	serverURL := resolveServerURL(pf.restCfg.Host, namespace, podName)
-	dialer := spdy.NewDialer(
-		upgrader,
-		&http.Client{Transport: roundTripper},
-		http.MethodPost,
-		&serverURL,
-	)
-
-	go func() {
-		defer close(errCh)
-		if err = pf.forwarder.forward(
-			dialer,
-			[]string{fmt.Sprintf("%d:%d", freePort, targetPort)},
-			process.stopCh, process.startedCh,
-			os.Stdout, os.Stderr, // todo: maybe log std err
-		); err != nil {
-			errCh <- err
+	if err := pf.forwarder.forward(
+		dialer,
+		process.ports,
+		process.connCh,
+		process.hooks(),
+		attempt.stopCh, attempt.readyCh,
+	); err != nil {
+		return fmt.Errorf(
+			"pod %s ports %v forward error in namespace %s: %w",
+			podName, process.forwardedPorts(), namespace, err,
+		)
+	}
+
+	return nil
+}
+
+// podDialer upgrades the streaming connection to the pod,
+// with the connection pooling the connection is shared with the other forwards to the pod
+func (pf *PortForwarder) podDialer(ctx context.Context, namespace, podName string) (httpstream.Dialer, error) {
+	serverURL := resolveServerURL(pf.coreClient, namespace, podName)
+
+	dialer, err := newDialer(ctx, pf.transport, pf.restCfg, serverURL)
+	if err != nil {
+		return nil, err
+	}
+
+	dialer = &tracedDialer{
+		Dialer: dialer,
+		ctx:    ctx,
+		tracer: pf.tracer(),
+		attrs: []attribute.KeyValue{
+			namespaceKey.String(namespace),
+			podKey.String(podName),
+			transportKey.String(pf.transport.String()),
+		},
+	}
+
+	if pf.connPool == nil {
+		return dialer, nil
+	}
+
+	return &pooledDialer{pool: pf.connPool, key: namespace + "/" + podName, dialer: dialer}, nil
+}
+
+// resolvePodPorts resolves numeric and named container ports of the pod,
+// duplicates are forwarded only once
+func resolvePodPorts(pod *corev1.Pod, port uint, ports []intstr.IntOrString) ([]uint, error) {
+	resolved := make([]uint, 0, len(ports)+1)
+	seen := make(map[uint]bool, len(ports)+1)
+	add := func(p uint) {
+		if !seen[p] {
+			seen[p] = true
+			resolved = append(resolved, p)
 		}
-	}()
-
-	select {
-	case <-process.stopCh:
-		return nil
-	case pfErr, ok := <-errCh:
-		if ok {
-			return fmt.Errorf(
-				"pod %s port %d forward error in namespace %s: %w",
-				podName, freePort, namespace, pfErr,
+	}
+
+	if port != 0 {
+		add(port)
+	}
+
+	for _, p := range ports {
+		if p.Type == intstr.Int {
+			add(uint(p.IntVal))
+			continue
+		}
+
+		containerPort, ok := findContainerPort(pod, p.StrVal)
+		if !ok {
+			return nil, fmt.Errorf(
+				"%w: pod %s does not have a container port named %s",
+				ErrPodPortNotFound, pod.GetName(), p.StrVal,
 			)
 		}
-		return nil
+		add(containerPort)
 	}
+
+	return resolved, nil
 }
 
-func resolveServerURL(host, namespace, podName string) url.URL {
-	path := fmt.Sprintf(
-		"/api/v1/namespaces/%s/pods/%s/portforward",
-		namespace, podName,
-	)
-	host = strings.TrimPrefix(host, "http://")
-	host = strings.TrimPrefix(host, "https://")
-	serverURL := url.URL{Scheme: "https", Path: path, Host: host}
-	return serverURL
+func findContainerPort(pod *corev1.Pod, name string) (uint, bool) {
+	for _, container := range pod.Spec.Containers {
+		for _, cp := range container.Ports {
+			if cp.Name == name {
+				return uint(cp.ContainerPort), true
+			}
+		}
+	}
+
+	return 0, false
+}
+
+func newCoreRESTClient(restCfg *rest.Config) (rest.Interface, error) {
+	client, err := corev1client.NewForConfig(restCfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create core REST client: %w", err)
+	}
+	return client.RESTClient(), nil
+}
+
+// resolveServerURL builds the portforward URL with the core REST client the same way kubectl does,
+// so the scheme and the path prefix of the host, e.g. /k8s/clusters/c-xxx of Rancher, are kept
+func resolveServerURL(client rest.Interface, namespace, podName string) *url.URL {
+	return client.
+		Post().
+		Resource("pods").
+		Namespace(namespace).
+		Name(podName).
+		SubResource("portforward").
+		URL()
 }
 
 func getPodName(
@@ -203,12 +520,31 @@ func getPodName(
 	provider podProvider,
 	target *TargetPod,
 ) (string, error) {
+	pod, err := resolvePod(ctx, provider, target)
+	if err != nil {
+		return "", err
+	}
+
+	return pod.GetName(), nil
+}
+
+func resolvePod(
+	ctx context.Context,
+	provider podProvider,
+	target *TargetPod,
+) (*corev1.Pod, error) {
 	if target.Name != "" {
 		pod, err := provider.getPod(ctx, target.Namespace, target.Name)
 		if err != nil {
-			return "", fmt.Errorf("%w: %s", ErrPodNotFound, err.Error())
+			return nil, fmt.Errorf("%w: %s", ErrPodNotFound, err.Error())
 		}
-		return pod.Name, nil
+
+		// the pod picked by name only has to be running, like with kubectl port-forward,
+		// so the pods failing the readiness probe can be debugged
+		if reason := podNotRunningReason(pod); reason != "" {
+			return nil, fmt.Errorf("%w: %s (%s)", ErrNoReadyPod, pod.GetName(), reason)
+		}
+		return pod, nil
 	}
 
 	pods, err := provider.listPods(ctx, &listPodsCommand{
@@ -216,38 +552,15 @@ func getPodName(
 		labelSelectors: target.LabelSelector,
 	})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	if len(pods.Items) < 1 {
-		return "", fmt.Errorf(
+		return nil, fmt.Errorf(
 			"%w: pods not found in [%s] namespace with provider %+v",
 			ErrPodNotFound, target.Namespace, target.LabelSelector,
 		)
 	}
 
-	podName := pods.Items[0].GetName()
-	if podName == "" {
-		return "", fmt.Errorf("%w: pod name should not be empty", ErrPodNotFound)
-	}
-
-	return podName, nil
-}
-
-type spdyForwarder struct{}
-
-func (f *spdyForwarder) forward(
-	dialer httpstream.Dialer,
-	ports []string,
-	stopChan <-chan struct{},
-	readyChan chan struct{},
-	out,
-	errOut io.Writer,
-) error {
-	forwarder, err := portforward.New(dialer, ports, stopChan, readyChan, out, errOut)
-	if err != nil {
-		return err
-	}
-
-	return forwarder.ForwardPorts()
+	return pickPod(pods.Items, target.PodSelectionStrategy)
 }
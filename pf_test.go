@@ -3,20 +3,27 @@ package portforwarder
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
 	"k8s.io/client-go/rest"
+	"net"
 	"net/url"
+	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 )
 
 func TestNewPortForwarder(t *testing.T) {
 	t.Run("valid connector", func(t *testing.T) {
-		mc := newMockConnector(t)
+		mc := NewMockConnector(t)
 		mc.EXPECT().Connect().Times(1).Return(&rest.Config{}, &kubernetes.Clientset{}, nil)
 		pf, err := NewPortForwarder(mc)
 		assert.NoError(t, err)
@@ -24,12 +31,27 @@ func TestNewPortForwarder(t *testing.T) {
 
 		assert.NotNil(t, pf.forwarder)
 		assert.NotNil(t, pf.restCfg)
-		assert.NotNil(t, pf.freePortProvider)
+		assert.NotNil(t, pf.coreClient)
+		assert.NotNil(t, pf.listenerProvider)
 		assert.NotNil(t, pf.podProvider)
 	})
 
+	t.Run("existing client", func(t *testing.T) {
+		pod := readyPod("api-1")
+		pod.Namespace = "team-a"
+
+		pf, err := NewPortForwarder(NewRestConfigConnector(
+			&rest.Config{}, fake.NewSimpleClientset(&pod), WithNamespace("team-a"),
+		))
+		require.NoError(t, err)
+
+		name, err := getPodName(context.TODO(), pf.podProvider, &TargetPod{Name: "api-1", Namespace: pf.namespace()})
+		require.NoError(t, err)
+		assert.Equal(t, "api-1", name)
+	})
+
 	t.Run("errored connector", func(t *testing.T) {
-		mc := newMockConnector(t)
+		mc := NewMockConnector(t)
 		mc.EXPECT().Connect().Times(1).Return(nil, nil, errors.New("connector error"))
 		pf, err := NewPortForwarder(mc)
 		require.Error(t, err)
@@ -44,11 +66,10 @@ func TestPortForwarder_PortForwardAPod(t *testing.T) {
 		ctx := context.TODO()
 		namespace := "kafka-ns"
 		ls := map[string]string{"app": "foo"}
-		pod := v1.Pod{}
-		pod.Name = "kafka-pod-0"
+		pod := readyPod("kafka-pod-0")
 
-		fpp := newMockFreePortProvider(t)
-		fpp.EXPECT().getFreePort().Times(1).Return(uint(3999), nil)
+		lp := newMockListenerProvider(t)
+		lp.EXPECT().listen([]string{"localhost"}, uint(0)).Times(1).Return(newTestListeners(3999), nil)
 
 		pl := newMockPodProvider(t)
 		pl.EXPECT().
@@ -62,16 +83,16 @@ func TestPortForwarder_PortForwardAPod(t *testing.T) {
 
 		f := newMockPortForwarder(t)
 		f.EXPECT().
-			forward(mock.Anything, []string{"3999:3000"}, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			forward(mock.Anything, matchForwardedPorts("3999:3000"), mock.Anything, mock.Anything, mock.Anything, mock.Anything).
 			Times(1).
 			Return(nil)
 
-		pf := &PortForwarder{
-			freePortProvider: fpp,
+		pf := withCoreClient(t, &PortForwarder{
+			listenerProvider: lp,
 			podProvider:      pl,
 			forwarder:        f,
 			restCfg:          restCfg,
-		}
+		})
 
 		process, err := pf.PortForwardAPod(
 			context.TODO(),
@@ -93,13 +114,192 @@ func TestPortForwarder_PortForwardAPod(t *testing.T) {
 	})
 }
 
+func TestPortForwarder_PortForwardAPod_defaultNamespace(t *testing.T) {
+	ctx := context.TODO()
+	pod := readyPod("api-1")
+
+	pl := newMockPodProvider(t)
+	pl.EXPECT().getPod(ctx, "team-a", "api-1").Times(1).Return(&pod, nil)
+
+	lp := newMockListenerProvider(t)
+	lp.EXPECT().listen([]string{"localhost"}, uint(0)).Times(1).Return(newTestListeners(3999), nil)
+
+	f := newMockPortForwarder(t)
+	f.EXPECT().
+		forward(mock.Anything, matchForwardedPorts("3999:8080"), mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Times(1).
+		Return(nil)
+
+	pf := withCoreClient(t, &PortForwarder{
+		listenerProvider: lp,
+		podProvider:      pl,
+		forwarder:        f,
+		restCfg:          &rest.Config{},
+		defaultNamespace: "team-a",
+	})
+
+	target := &TargetPod{Port: 8080, Name: "api-1"}
+	process, err := pf.PortForwardAPod(ctx, target)
+	require.NoError(t, err)
+
+	<-process.Finished()
+	assert.NoError(t, process.Err())
+	assert.Equal(t, "team-a", target.Namespace)
+}
+
+func TestPortForwarder_PortForwardAPod_multiplePorts(t *testing.T) {
+	ctx := context.TODO()
+	pod := readyPod("kafka-broker-0")
+	pod.Spec.Containers = []v1.Container{
+		{Ports: []v1.ContainerPort{{Name: "broker", ContainerPort: 9092}, {Name: "jmx", ContainerPort: 9999}}},
+	}
+
+	pl := newMockPodProvider(t)
+	pl.EXPECT().getPod(ctx, "kafka-ns", "kafka-broker-0").Times(1).Return(&pod, nil)
+
+	lp := newMockListenerProvider(t)
+	lp.EXPECT().listen([]string{"localhost"}, uint(0)).Times(1).Return(newTestListeners(4001), nil)
+	lp.EXPECT().listen([]string{"localhost"}, uint(0)).Times(1).Return(newTestListeners(4002), nil)
+
+	f := newMockPortForwarder(t)
+	f.EXPECT().
+		forward(mock.Anything, matchForwardedPorts("4001:9092", "4002:9999"), mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Times(1).
+		Return(nil)
+
+	pf := withCoreClient(t, &PortForwarder{
+		listenerProvider: lp,
+		podProvider:      pl,
+		forwarder:        f,
+		restCfg:          &rest.Config{},
+	})
+
+	process, err := pf.PortForwardAPod(ctx, &TargetPod{
+		Port:      9092,
+		Ports:     []intstr.IntOrString{intstr.FromString("broker"), intstr.FromString("jmx")},
+		Namespace: "kafka-ns",
+		Name:      "kafka-broker-0",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint(4001), process.Port)
+	assert.Equal(t, map[uint]uint{9092: 4001, 9999: 4002}, process.Ports())
+
+	go func() {
+		<-time.After(500 * time.Millisecond)
+		process.Stop()
+	}()
+
+	<-process.Finished()
+	assert.NoError(t, process.Err())
+}
+
+func TestPortForwarder_PortForwardAPod_localEndpoint(t *testing.T) {
+	ctx := context.TODO()
+	pod := readyPod("postgres-0")
+
+	pl := newMockPodProvider(t)
+	pl.EXPECT().getPod(ctx, "db", "postgres-0").Times(1).Return(&pod, nil)
+
+	lp := newMockListenerProvider(t)
+	lp.EXPECT().listen([]string{"0.0.0.0"}, uint(15432)).Times(1).Return(newTestListeners(15432), nil)
+
+	f := newMockPortForwarder(t)
+	f.EXPECT().
+		forward(mock.Anything, matchForwardedPorts("15432:5432"), mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Times(1).
+		Return(nil)
+
+	pf := withCoreClient(t, &PortForwarder{
+		listenerProvider: lp,
+		podProvider:      pl,
+		forwarder:        f,
+		restCfg:          &rest.Config{},
+	})
+
+	process, err := pf.PortForwardAPod(ctx, &TargetPod{
+		Port:           5432,
+		Namespace:      "db",
+		Name:           "postgres-0",
+		LocalPort:      15432,
+		LocalAddresses: []string{"0.0.0.0"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, uint(15432), process.Port)
+
+	go func() {
+		<-time.After(500 * time.Millisecond)
+		process.Stop()
+	}()
+
+	<-process.Finished()
+	assert.NoError(t, process.Err())
+}
+
+func TestPortForwarder_PortForwardAPod_localSocket(t *testing.T) {
+	ctx := context.TODO()
+	pod := readyPod("postgres-0")
+	socket := filepath.Join(t.TempDir(), ".s.PGSQL.5432")
+
+	pl := newMockPodProvider(t)
+	pl.EXPECT().getPod(ctx, "db", "postgres-0").Times(1).Return(&pod, nil)
+
+	f := newMockPortForwarder(t)
+	f.EXPECT().
+		forward(mock.Anything, matchForwardedPorts(socket+":5432"), mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		RunAndReturn(forwardUntilStopped).
+		Times(1)
+
+	pf := withCoreClient(t, &PortForwarder{
+		socketListenerProvider: newNetListenerProvider("unix"),
+		podProvider:            pl,
+		forwarder:              f,
+		restCfg:                &rest.Config{},
+	})
+
+	process, err := pf.PortForwardAPod(ctx, &TargetPod{
+		Port:        5432,
+		Namespace:   "db",
+		Name:        "postgres-0",
+		LocalSocket: socket,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, socket, process.Socket)
+	assert.Equal(t, uint(0), process.Port)
+
+	<-process.Started()
+	conn, err := net.Dial("unix", socket)
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	process.Stop()
+	assert.NoError(t, process.Err())
+	assert.NoFileExists(t, socket)
+}
+
+func Test_resolvePodPorts(t *testing.T) {
+	pod := readyPod("nginx")
+	pod.Spec.Containers = []v1.Container{
+		{Ports: []v1.ContainerPort{{Name: "http", ContainerPort: 80}}},
+	}
+
+	t.Run("unknown named port", func(t *testing.T) {
+		_, err := resolvePodPorts(&pod, 0, []intstr.IntOrString{intstr.FromString("https")})
+		require.ErrorIs(t, err, ErrPodPortNotFound)
+	})
+
+	t.Run("numeric and named ports", func(t *testing.T) {
+		ports, err := resolvePodPorts(&pod, 8080, []intstr.IntOrString{intstr.FromInt(9090), intstr.FromString("http")})
+		require.NoError(t, err)
+		assert.Equal(t, []uint{8080, 9090, 80}, ports)
+	})
+}
+
 func Test_getPodName(t *testing.T) {
 	t.Run("find by namespace and provider", func(t *testing.T) {
 		ctx := context.TODO()
 		namespace := "kafka-ns"
 		ls := map[string]string{"app": "foo"}
-		pod := v1.Pod{}
-		pod.Name = "kafka-pod-0"
+		pod := readyPod("kafka-pod-0")
 
 		pl := newMockPodProvider(t)
 		pl.EXPECT().
@@ -120,9 +320,89 @@ func Test_getPodName(t *testing.T) {
 	})
 }
 
+func Test_getPodName_readiness(t *testing.T) {
+	pending := readyPod("kafka-pod-0")
+	pending.Status.Phase = v1.PodPending
+
+	terminating := readyPod("kafka-pod-1")
+	terminating.DeletionTimestamp = &metav1.Time{Time: time.Now()}
+
+	crashLooping := readyPod("kafka-pod-2")
+	crashLooping.Status.Conditions[0].Status = v1.ConditionFalse
+
+	t.Run("skips pods which are not ready", func(t *testing.T) {
+		ctx := context.TODO()
+		pl := newMockPodProvider(t)
+		pl.EXPECT().
+			listPods(ctx, mock.Anything).
+			Times(1).
+			Return(&v1.PodList{Items: []v1.Pod{pending, terminating, crashLooping, readyPod("kafka-pod-3")}}, nil)
+
+		podName, err := getPodName(ctx, pl, &TargetPod{
+			Namespace:     "kafka-ns",
+			LabelSelector: map[string]string{"app": "kafka"},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "kafka-pod-3", podName)
+	})
+
+	t.Run("lists rejected candidates", func(t *testing.T) {
+		ctx := context.TODO()
+		pl := newMockPodProvider(t)
+		pl.EXPECT().
+			listPods(ctx, mock.Anything).
+			Times(1).
+			Return(&v1.PodList{Items: []v1.Pod{pending, terminating, crashLooping}}, nil)
+
+		_, err := getPodName(ctx, pl, &TargetPod{
+			Namespace:     "kafka-ns",
+			LabelSelector: map[string]string{"app": "kafka"},
+		})
+		require.ErrorIs(t, err, ErrNoReadyPod)
+		assert.Contains(t, err.Error(), `kafka-pod-0 (phase is "Pending")`)
+		assert.Contains(t, err.Error(), "kafka-pod-1 (terminating)")
+		assert.Contains(t, err.Error(), "kafka-pod-2 (not ready)")
+	})
+
+	t.Run("pod by name must be running", func(t *testing.T) {
+		ctx := context.TODO()
+		pl := newMockPodProvider(t)
+		pl.EXPECT().getPod(ctx, "kafka-ns", "kafka-pod-0").Times(1).Return(&pending, nil)
+		pl.EXPECT().getPod(ctx, "kafka-ns", "kafka-pod-1").Times(1).Return(&terminating, nil)
+
+		_, err := getPodName(ctx, pl, &TargetPod{Namespace: "kafka-ns", Name: "kafka-pod-0"})
+		require.ErrorIs(t, err, ErrNoReadyPod)
+		assert.Contains(t, err.Error(), `kafka-pod-0 (phase is "Pending")`)
+
+		_, err = getPodName(ctx, pl, &TargetPod{Namespace: "kafka-ns", Name: "kafka-pod-1"})
+		require.ErrorIs(t, err, ErrNoReadyPod)
+		assert.Contains(t, err.Error(), "kafka-pod-1 (terminating)")
+	})
+
+	t.Run("pod by name may be not ready", func(t *testing.T) {
+		ctx := context.TODO()
+		pl := newMockPodProvider(t)
+		pl.EXPECT().getPod(ctx, "kafka-ns", "kafka-pod-2").Times(1).Return(&crashLooping, nil)
+
+		podName, err := getPodName(ctx, pl, &TargetPod{Namespace: "kafka-ns", Name: "kafka-pod-2"})
+		require.NoError(t, err)
+		assert.Equal(t, "kafka-pod-2", podName)
+	})
+}
+
+// withCoreClient sets the core REST client built from the rest config, like NewPortForwarder does
+func withCoreClient(t *testing.T, pf *PortForwarder) *PortForwarder {
+	t.Helper()
+
+	coreClient, err := newCoreRESTClient(pf.restCfg)
+	require.NoError(t, err)
+	pf.coreClient = coreClient
+	return pf
+}
+
 func Test_resolveServerURL(t *testing.T) {
 	type args struct {
-		host      string
+		restCfg   *rest.Config
 		namespace string
 		podName   string
 	}
@@ -134,25 +414,49 @@ func Test_resolveServerURL(t *testing.T) {
 		{
 			name: "https local host",
 			args: args{
-				host:      "https://127.0.0.1:5545",
+				restCfg:   &rest.Config{Host: "https://127.0.0.1:5545"},
 				namespace: "nginx-ns",
 				podName:   "nginx",
 			},
 			want: resolveTestURL(t, "https://127.0.0.1:5545/api/v1/namespaces/nginx-ns/pods/nginx/portforward"),
 		},
 		{
-			name: "https local host",
+			name: "plain http host",
+			args: args{
+				restCfg:   &rest.Config{Host: "http://some-cluster:8001"},
+				namespace: "my-namespace",
+				podName:   "kafka-broker-0",
+			},
+			want: resolveTestURL(t, "http://some-cluster:8001/api/v1/namespaces/my-namespace/pods/kafka-broker-0/portforward"),
+		},
+		{
+			name: "host path prefix",
+			args: args{
+				restCfg:   &rest.Config{Host: "https://rancher.example.com/k8s/clusters/c-m-abc123"},
+				namespace: "my-namespace",
+				podName:   "kafka-broker-0",
+			},
+			want: resolveTestURL(t, "https://rancher.example.com/k8s/clusters/c-m-abc123/api/v1/namespaces/my-namespace/pods/kafka-broker-0/portforward"),
+		},
+		{
+			name: "host without scheme and with TLS",
 			args: args{
-				host:      "http://some-cluster:99882",
+				restCfg: &rest.Config{
+					Host:            "some-cluster:6443",
+					TLSClientConfig: rest.TLSClientConfig{Insecure: true},
+				},
 				namespace: "my-namespace",
 				podName:   "kafka-broker-0",
 			},
-			want: resolveTestURL(t, "https://some-cluster:99882/api/v1/namespaces/my-namespace/pods/kafka-broker-0/portforward"),
+			want: resolveTestURL(t, "https://some-cluster:6443/api/v1/namespaces/my-namespace/pods/kafka-broker-0/portforward"),
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			assert.Equalf(t, tt.want, resolveServerURL(tt.args.host, tt.args.namespace, tt.args.podName), "resolveServerURL(%v, %v, %v)", tt.args.host, tt.args.namespace, tt.args.podName)
+			client, err := newCoreRESTClient(tt.args.restCfg)
+			require.NoError(t, err)
+			got := resolveServerURL(client, tt.args.namespace, tt.args.podName)
+			assert.Equalf(t, tt.want, *got, "resolveServerURL(%v, %v, %v)", tt.args.restCfg.Host, tt.args.namespace, tt.args.podName)
 		})
 	}
 }
@@ -166,3 +470,52 @@ func resolveTestURL(t *testing.T, path string) url.URL {
 	}
 	return *u
 }
+
+func readyPod(name string) v1.Pod {
+	pod := v1.Pod{}
+	pod.Name = name
+	pod.Status.Phase = v1.PodRunning
+	pod.Status.Conditions = []v1.PodCondition{{Type: v1.PodReady, Status: v1.ConditionTrue}}
+	return pod
+}
+
+func matchForwardedPorts(expected ...string) interface{} {
+	return mock.MatchedBy(func(ports []*forwardedPort) bool {
+		if len(ports) != len(expected) {
+			return false
+		}
+
+		for i, port := range ports {
+			if fmt.Sprintf("%s:%d", port.localEndpoint(), port.remote) != expected[i] {
+				return false
+			}
+		}
+
+		return true
+	})
+}
+
+// testListener is a bound listener stub, which accepts no connections until closed
+type testListener struct {
+	port   int
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newTestListeners(port int) []net.Listener {
+	return []net.Listener{&testListener{port: port, closed: make(chan struct{})}}
+}
+
+func (l *testListener) Accept() (net.Conn, error) {
+	<-l.closed
+	return nil, net.ErrClosed
+}
+
+func (l *testListener) Close() error {
+	l.once.Do(func() { close(l.closed) })
+	return nil
+}
+
+func (l *testListener) Addr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: l.port}
+}
@@ -4,17 +4,19 @@ import (
 	"context"
 	"fmt"
 	"github.com/pkg/errors"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
 	"strings"
 )
 
 type provider struct {
-	clientSet *kubernetes.Clientset
+	clientSet kubernetes.Interface
 }
 
-func newSelectorFromKubeConfig(k8sClientSet *kubernetes.Clientset) *provider {
+func newSelectorFromKubeConfig(k8sClientSet kubernetes.Interface) *provider {
 	return &provider{clientSet: k8sClientSet}
 }
 
@@ -22,6 +24,9 @@ type listPodsCommand struct {
 	namespace      string
 	labelSelectors map[string]string
 	fieldSelectors map[string]string
+	// rawLabelSelector is an already serialized label selector
+	// which is combined with labelSelectors, e.g. for set based workload selectors
+	rawLabelSelector string
 }
 
 func (p *provider) getPod(ctx context.Context, namespace, name string) (*corev1.Pod, error) {
@@ -39,11 +44,111 @@ func (p *provider) getPod(ctx context.Context, namespace, name string) (*corev1.
 	return pod, nil
 }
 
+func (p *provider) getService(ctx context.Context, namespace, name string) (*corev1.Service, error) {
+	svc, err := p.clientSet.
+		CoreV1().
+		Services(namespace).
+		Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf(
+			"%w: failed to get service %s in namespace %s",
+			err, name, namespace,
+		)
+	}
+
+	return svc, nil
+}
+
+func (p *provider) getWorkloadSelector(
+	ctx context.Context,
+	kind WorkloadKind,
+	namespace,
+	name string,
+) (*workloadSelector, error) {
+	var (
+		obj      metav1.Object
+		selector *metav1.LabelSelector
+	)
+
+	apps := p.clientSet.AppsV1()
+	switch kind {
+	case Deployment:
+		d, err := apps.Deployments(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to get deployment %s in namespace %s", err, name, namespace)
+		}
+		return p.getDeploymentSelector(ctx, d)
+	case StatefulSet:
+		s, err := apps.StatefulSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to get statefulset %s in namespace %s", err, name, namespace)
+		}
+		obj, selector = s, s.Spec.Selector
+	case ReplicaSet:
+		rs, err := apps.ReplicaSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to get replicaset %s in namespace %s", err, name, namespace)
+		}
+		obj, selector = rs, rs.Spec.Selector
+	case DaemonSet:
+		ds, err := apps.DaemonSets(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to get daemonset %s in namespace %s", err, name, namespace)
+		}
+		obj, selector = ds, ds.Spec.Selector
+	default:
+		return nil, fmt.Errorf("unsupported workload kind %q", kind)
+	}
+
+	s, err := metav1.LabelSelectorAsSelector(selector)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid selector of %s %s", kind, name)
+	}
+
+	return &workloadSelector{
+		selector:  s.String(),
+		ownerUIDs: []types.UID{obj.GetUID()},
+	}, nil
+}
+
+// getDeploymentSelector resolves the deployment pods through the replica sets
+// controlled by the deployment, since pods are not owned by the deployment directly
+func (p *provider) getDeploymentSelector(
+	ctx context.Context,
+	d *appsv1.Deployment,
+) (*workloadSelector, error) {
+	s, err := metav1.LabelSelectorAsSelector(d.Spec.Selector)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid selector of deployment %s", d.Name)
+	}
+
+	opts := metav1.ListOptions{LabelSelector: s.String()}
+	replicaSets, err := p.clientSet.
+		AppsV1().
+		ReplicaSets(d.Namespace).
+		List(ctx, opts)
+	if err != nil {
+		return nil, errors.Wrapf(
+			err, "failed to list replica sets with opts %+v",
+			opts,
+		)
+	}
+
+	ws := &workloadSelector{selector: s.String()}
+	for i := range replicaSets.Items {
+		if metav1.IsControlledBy(&replicaSets.Items[i], d) {
+			ws.ownerUIDs = append(ws.ownerUIDs, replicaSets.Items[i].GetUID())
+		}
+	}
+
+	return ws, nil
+}
+
 func (p *provider) listPods(
 	ctx context.Context, cmd *listPodsCommand,
 ) (*corev1.PodList, error) {
 	opts := metav1.ListOptions{
-		LabelSelector: buildSelector(cmd.labelSelectors),
+		LabelSelector: joinSelectors(buildSelector(cmd.labelSelectors), cmd.rawLabelSelector),
 		FieldSelector: buildSelector(cmd.fieldSelectors),
 	}
 	resp, err := p.clientSet.
@@ -71,3 +176,14 @@ func buildSelector(labels map[string]string) string {
 
 	return strings.Join(selectors, ",")
 }
+
+func joinSelectors(selectors ...string) string {
+	nonEmpty := make([]string, 0, len(selectors))
+	for _, s := range selectors {
+		if s != "" {
+			nonEmpty = append(nonEmpty, s)
+		}
+	}
+
+	return strings.Join(nonEmpty, ",")
+}
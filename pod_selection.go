@@ -0,0 +1,166 @@
+package portforwarder
+
+import (
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"math/rand"
+	"strings"
+	"sync/atomic"
+)
+
+// PodSelectionStrategy picks one pod out of the running and ready candidates
+type PodSelectionStrategy interface {
+	SelectPod(pods []corev1.Pod) (*corev1.Pod, error)
+}
+
+// PodSelectorFunc is a custom PodSelectionStrategy
+type PodSelectorFunc func(pods []corev1.Pod) (*corev1.Pod, error)
+
+func (f PodSelectorFunc) SelectPod(pods []corev1.Pod) (*corev1.Pod, error) {
+	return f(pods)
+}
+
+// SelectFirstReady picks the first ready pod in the order returned by the API server,
+// which is the default strategy
+func SelectFirstReady() PodSelectionStrategy {
+	return PodSelectorFunc(func(pods []corev1.Pod) (*corev1.Pod, error) {
+		return &pods[0], nil
+	})
+}
+
+// SelectNewest picks the most recently created ready pod
+func SelectNewest() PodSelectionStrategy {
+	return PodSelectorFunc(func(pods []corev1.Pod) (*corev1.Pod, error) {
+		newest := &pods[0]
+		for i := range pods[1:] {
+			if newest.CreationTimestamp.Before(&pods[i+1].CreationTimestamp) {
+				newest = &pods[i+1]
+			}
+		}
+		return newest, nil
+	})
+}
+
+// SelectOldest picks the earliest created ready pod
+func SelectOldest() PodSelectionStrategy {
+	return PodSelectorFunc(func(pods []corev1.Pod) (*corev1.Pod, error) {
+		oldest := &pods[0]
+		for i := range pods[1:] {
+			if pods[i+1].CreationTimestamp.Before(&oldest.CreationTimestamp) {
+				oldest = &pods[i+1]
+			}
+		}
+		return oldest, nil
+	})
+}
+
+// SelectRandom picks a random ready pod
+func SelectRandom() PodSelectionStrategy {
+	return PodSelectorFunc(func(pods []corev1.Pod) (*corev1.Pod, error) {
+		return &pods[rand.Intn(len(pods))], nil
+	})
+}
+
+// SelectRoundRobin spreads the calls across the ready pods,
+// the same strategy value should be reused between the calls
+func SelectRoundRobin() PodSelectionStrategy {
+	return &roundRobin{}
+}
+
+type roundRobin struct {
+	next uint64
+}
+
+func (r *roundRobin) SelectPod(pods []corev1.Pod) (*corev1.Pod, error) {
+	n := atomic.AddUint64(&r.next, 1) - 1
+	return &pods[n%uint64(len(pods))], nil
+}
+
+// SelectByNode picks the first ready pod scheduled on the given node
+func SelectByNode(nodeName string) PodSelectionStrategy {
+	return PodSelectorFunc(func(pods []corev1.Pod) (*corev1.Pod, error) {
+		for i := range pods {
+			if pods[i].Spec.NodeName == nodeName {
+				return &pods[i], nil
+			}
+		}
+
+		return nil, fmt.Errorf("%w: no ready pod on node %s", ErrPodNotFound, nodeName)
+	})
+}
+
+// pickPod picks a pod with the strategy out of the pods which are running, not terminating and ready,
+// when there is none it reports why each of the candidates was rejected
+func pickPod(pods []corev1.Pod, strategy PodSelectionStrategy) (*corev1.Pod, error) {
+	ready := make([]corev1.Pod, 0, len(pods))
+	rejections := make([]string, 0, len(pods))
+	for i := range pods {
+		pod := &pods[i]
+		if pod.GetName() == "" {
+			return nil, fmt.Errorf("%w: pod name should not be empty", ErrPodNotFound)
+		}
+
+		if reason := podNotReadyReason(pod); reason != "" {
+			rejections = append(rejections, fmt.Sprintf("%s (%s)", pod.GetName(), reason))
+			continue
+		}
+
+		ready = append(ready, *pod)
+	}
+
+	if len(ready) == 0 {
+		return nil, fmt.Errorf(
+			"%w: rejected candidates: %s",
+			ErrNoReadyPod, strings.Join(rejections, ", "),
+		)
+	}
+
+	if strategy == nil {
+		strategy = SelectFirstReady()
+	}
+
+	pod, err := strategy.SelectPod(ready)
+	if err != nil {
+		return nil, err
+	}
+
+	if pod == nil {
+		return nil, fmt.Errorf("%w: pod selection strategy selected no pod", ErrPodNotFound)
+	}
+
+	return pod, nil
+}
+
+func podNotReadyReason(pod *corev1.Pod) string {
+	if reason := podNotRunningReason(pod); reason != "" {
+		return reason
+	}
+
+	if !isPodReady(pod) {
+		return "not ready"
+	}
+
+	return ""
+}
+
+func podNotRunningReason(pod *corev1.Pod) string {
+	if pod.DeletionTimestamp != nil {
+		return "terminating"
+	}
+
+	if pod.Status.Phase != corev1.PodRunning {
+		return fmt.Sprintf("phase is %q", pod.Status.Phase)
+	}
+
+	return ""
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,92 @@
+package portforwarder
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"testing"
+	"time"
+)
+
+func Test_pickPod_strategies(t *testing.T) {
+	now := time.Now()
+	pods := []v1.Pod{
+		podCreatedAt("db-0", "node-a", now.Add(-time.Hour)),
+		podCreatedAt("db-1", "node-b", now),
+		podCreatedAt("db-2", "node-c", now.Add(-2*time.Hour)),
+	}
+
+	tests := []struct {
+		name     string
+		strategy PodSelectionStrategy
+		want     string
+	}{
+		{name: "default", strategy: nil, want: "db-0"},
+		{name: "first ready", strategy: SelectFirstReady(), want: "db-0"},
+		{name: "newest", strategy: SelectNewest(), want: "db-1"},
+		{name: "oldest", strategy: SelectOldest(), want: "db-2"},
+		{name: "by node", strategy: SelectByNode("node-b"), want: "db-1"},
+		{
+			name: "custom func",
+			strategy: PodSelectorFunc(func(pods []v1.Pod) (*v1.Pod, error) {
+				return &pods[len(pods)-1], nil
+			}),
+			want: "db-2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod, err := pickPod(pods, tt.strategy)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, pod.Name)
+		})
+	}
+
+	t.Run("round robin across calls", func(t *testing.T) {
+		rr := SelectRoundRobin()
+		var names []string
+		for i := 0; i < 4; i++ {
+			pod, err := pickPod(pods, rr)
+			require.NoError(t, err)
+			names = append(names, pod.Name)
+		}
+		assert.Equal(t, []string{"db-0", "db-1", "db-2", "db-0"}, names)
+	})
+
+	t.Run("random picks one of the pods", func(t *testing.T) {
+		pod, err := pickPod(pods, SelectRandom())
+		require.NoError(t, err)
+		assert.Contains(t, []string{"db-0", "db-1", "db-2"}, pod.Name)
+	})
+
+	t.Run("by node without pods on the node", func(t *testing.T) {
+		_, err := pickPod(pods, SelectByNode("node-x"))
+		require.ErrorIs(t, err, ErrPodNotFound)
+	})
+
+	t.Run("strategy error", func(t *testing.T) {
+		strategyErr := errors.New("no luck")
+		_, err := pickPod(pods, PodSelectorFunc(func([]v1.Pod) (*v1.Pod, error) {
+			return nil, strategyErr
+		}))
+		require.ErrorIs(t, err, strategyErr)
+	})
+
+	t.Run("strategy sees only ready pods", func(t *testing.T) {
+		notReady := podCreatedAt("db-3", "node-a", now.Add(time.Hour))
+		notReady.Status.Phase = v1.PodPending
+
+		pod, err := pickPod(append([]v1.Pod{notReady}, pods...), SelectNewest())
+		require.NoError(t, err)
+		assert.Equal(t, "db-1", pod.Name)
+	})
+}
+
+func podCreatedAt(name, node string, created time.Time) v1.Pod {
+	pod := readyPod(name)
+	pod.Spec.NodeName = node
+	pod.CreationTimestamp = metav1.NewTime(created)
+	return pod
+}
@@ -0,0 +1,190 @@
+package portforwarder
+
+import (
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// connPool shares one streaming connection per pod between the forwards and the dialed connections,
+// the connection is closed when the last of its users closes it
+type connPool struct {
+	mx    sync.Mutex
+	conns map[string]*pooledConn
+}
+
+func newConnPool() *connPool {
+	return &connPool{conns: make(map[string]*pooledConn)}
+}
+
+// pooledConn is the streaming connection to the pod with the number of its users
+type pooledConn struct {
+	key      string
+	conn     httpstream.Connection
+	protocol string
+	err      error
+	// dialed is closed once the connection is upgraded or failed to
+	dialed    chan struct{}
+	refs      int
+	requestID atomic.Int32
+}
+
+func (c *pooledConn) lost() bool {
+	if !isClosed(c.dialed) {
+		return false
+	}
+
+	if c.err != nil {
+		return true
+	}
+
+	select {
+	case <-c.conn.CloseChan():
+		return true
+	default:
+		return false
+	}
+}
+
+// dial returns the shared connection to the pod, the first user of the key upgrades it
+// and the concurrent ones wait for the upgrade, the lost connections are upgraded again
+func (p *connPool) dial(key string, dialer httpstream.Dialer, protocols ...string) (httpstream.Connection, string, error) {
+	p.mx.Lock()
+	pc, ok := p.conns[key]
+	first := !ok || pc.lost()
+	if first {
+		pc = &pooledConn{key: key, dialed: make(chan struct{})}
+		p.conns[key] = pc
+	}
+	pc.refs++
+	p.mx.Unlock()
+
+	if first {
+		pc.conn, pc.protocol, pc.err = dialer.Dial(protocols...)
+		close(pc.dialed)
+	} else {
+		<-pc.dialed
+	}
+
+	if pc.err != nil {
+		p.release(pc)
+		return nil, "", pc.err
+	}
+
+	return newSharedConn(p, pc), pc.protocol, nil
+}
+
+func (p *connPool) release(pc *pooledConn) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+
+	pc.refs--
+	if pc.refs > 0 {
+		return
+	}
+
+	if p.conns[pc.key] == pc {
+		delete(p.conns, pc.key)
+	}
+
+	if pc.conn != nil {
+		_ = pc.conn.Close()
+	}
+}
+
+// pooledDialer dials the pod through the pool
+type pooledDialer struct {
+	pool   *connPool
+	key    string
+	dialer httpstream.Dialer
+}
+
+func (d *pooledDialer) Dial(protocols ...string) (httpstream.Connection, string, error) {
+	return d.pool.dial(d.key, d.dialer, protocols...)
+}
+
+// sharedConn is the reference of a single user to the pooled connection,
+// closing it resets the streams of the user and releases the reference without affecting the other users
+type sharedConn struct {
+	httpstream.Connection
+	pool    *connPool
+	pc      *pooledConn
+	mx      sync.Mutex
+	streams map[httpstream.Stream]struct{}
+	closer  sync.Once
+	closeCh chan struct{}
+	doneCh  chan bool
+}
+
+func newSharedConn(pool *connPool, pc *pooledConn) *sharedConn {
+	c := &sharedConn{
+		Connection: pc.conn,
+		pool:       pool,
+		pc:         pc,
+		streams:    make(map[httpstream.Stream]struct{}),
+		closeCh:    make(chan struct{}),
+		doneCh:     make(chan bool),
+	}
+
+	go func() {
+		select {
+		case <-c.closeCh:
+		case <-pc.conn.CloseChan():
+		}
+		close(c.doneCh)
+	}()
+
+	return c
+}
+
+func (c *sharedConn) CreateStream(headers http.Header) (httpstream.Stream, error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	if isClosed(c.closeCh) {
+		return nil, net.ErrClosed
+	}
+
+	s, err := c.Connection.CreateStream(headers)
+	if err != nil {
+		return nil, err
+	}
+
+	c.streams[s] = struct{}{}
+	return s, nil
+}
+
+func (c *sharedConn) RemoveStreams(streams ...httpstream.Stream) {
+	c.mx.Lock()
+	for _, s := range streams {
+		delete(c.streams, s)
+	}
+	c.mx.Unlock()
+
+	c.Connection.RemoveStreams(streams...)
+}
+
+func (c *sharedConn) Close() error {
+	c.closer.Do(func() {
+		c.mx.Lock()
+		close(c.closeCh)
+		for s := range c.streams {
+			_ = s.Reset()
+		}
+		c.mx.Unlock()
+
+		c.pool.release(c.pc)
+	})
+	return nil
+}
+
+// CloseChan is closed when either this reference or the pooled connection is closed
+func (c *sharedConn) CloseChan() <-chan bool {
+	return c.doneCh
+}
+
+func (c *sharedConn) nextRequestID() int {
+	return int(c.pc.requestID.Add(1) - 1)
+}
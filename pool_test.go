@@ -0,0 +1,255 @@
+package portforwarder
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingDialer counts the upgrades of the streaming connections
+type countingDialer struct {
+	echoPodDialer
+	mx    sync.Mutex
+	dials int
+}
+
+func (d *countingDialer) Dial(protocols ...string) (httpstream.Connection, string, error) {
+	d.mx.Lock()
+	d.dials++
+	d.mx.Unlock()
+	return d.echoPodDialer.Dial(protocols...)
+}
+
+func (d *countingDialer) count() int {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	return d.dials
+}
+
+func createDataStream(t *testing.T, conn httpstream.Connection, requestID int) httpstream.Stream {
+	t.Helper()
+
+	headers := http.Header{}
+	headers.Set(corev1.StreamType, corev1.StreamTypeData)
+	headers.Set(corev1.PortHeader, "8080")
+	headers.Set(corev1.PortForwardRequestIDHeader, strconv.Itoa(requestID))
+
+	s, err := conn.CreateStream(headers)
+	require.NoError(t, err)
+	return s
+}
+
+func assertEcho(t *testing.T, s httpstream.Stream) {
+	t.Helper()
+
+	_, err := s.Write([]byte("ping"))
+	require.NoError(t, err)
+
+	resp := make([]byte, len("8080:ping"))
+	_, err = io.ReadFull(s, resp)
+	require.NoError(t, err)
+	assert.Equal(t, "8080:ping", string(resp))
+}
+
+func assertClosed(t *testing.T, ch <-chan bool) {
+	t.Helper()
+
+	select {
+	case <-ch:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection is not closed")
+	}
+}
+
+func assertOpen(t *testing.T, ch <-chan bool) {
+	t.Helper()
+
+	select {
+	case <-ch:
+		t.Fatal("connection is closed")
+	default:
+	}
+}
+
+func Test_connPool(t *testing.T) {
+	t.Run("connection is shared per key until the last user closes it", func(t *testing.T) {
+		pool := newConnPool()
+		dialer := &countingDialer{}
+
+		first, protocol, err := pool.dial("default/api-1", dialer, portforward.PortForwardProtocolV1Name)
+		require.NoError(t, err)
+		assert.Equal(t, portforward.PortForwardProtocolV1Name, protocol)
+
+		second, _, err := pool.dial("default/api-1", dialer, portforward.PortForwardProtocolV1Name)
+		require.NoError(t, err)
+		assert.Equal(t, 1, dialer.count())
+
+		other, _, err := pool.dial("default/api-2", dialer, portforward.PortForwardProtocolV1Name)
+		require.NoError(t, err)
+		assert.Equal(t, 2, dialer.count())
+		require.NoError(t, other.Close())
+
+		underlying := first.(*sharedConn).Connection
+		firstStream := createDataStream(t, first, requestIDs(first)())
+		secondStream := createDataStream(t, second, requestIDs(second)())
+		assertEcho(t, firstStream)
+
+		require.NoError(t, first.Close())
+		assertClosed(t, first.CloseChan())
+		assertOpen(t, second.CloseChan())
+		assertOpen(t, underlying.CloseChan())
+
+		_, err = firstStream.Read(make([]byte, 1))
+		require.Error(t, err, "streams of the closed user are reset")
+		assertEcho(t, secondStream)
+
+		_, err = first.CreateStream(http.Header{})
+		require.ErrorIs(t, err, net.ErrClosed)
+
+		require.NoError(t, second.Close())
+		assertClosed(t, underlying.CloseChan())
+
+		third, _, err := pool.dial("default/api-1", dialer, portforward.PortForwardProtocolV1Name)
+		require.NoError(t, err)
+		assert.Equal(t, 3, dialer.count())
+		require.NoError(t, third.Close())
+	})
+
+	t.Run("request ids are unique per connection", func(t *testing.T) {
+		pool := newConnPool()
+		dialer := &countingDialer{}
+
+		first, _, err := pool.dial("default/api-1", dialer, portforward.PortForwardProtocolV1Name)
+		require.NoError(t, err)
+		second, _, err := pool.dial("default/api-1", dialer, portforward.PortForwardProtocolV1Name)
+		require.NoError(t, err)
+
+		firstIDs, secondIDs := requestIDs(first), requestIDs(second)
+		assert.Equal(t, []int{0, 1, 2, 3}, []int{firstIDs(), secondIDs(), firstIDs(), secondIDs()})
+
+		require.NoError(t, first.Close())
+		require.NoError(t, second.Close())
+	})
+
+	t.Run("lost connection is dialed again", func(t *testing.T) {
+		pool := newConnPool()
+		dialer := &countingDialer{}
+
+		first, _, err := pool.dial("default/api-1", dialer, portforward.PortForwardProtocolV1Name)
+		require.NoError(t, err)
+
+		require.NoError(t, dialer.server.Close())
+		assertClosed(t, first.CloseChan())
+
+		second, _, err := pool.dial("default/api-1", dialer, portforward.PortForwardProtocolV1Name)
+		require.NoError(t, err)
+		assert.Equal(t, 2, dialer.count())
+		assertEcho(t, createDataStream(t, second, requestIDs(second)()))
+
+		require.NoError(t, first.Close())
+		assertOpen(t, second.CloseChan())
+		require.NoError(t, second.Close())
+	})
+
+	t.Run("dial errors are not pooled", func(t *testing.T) {
+		pool := newConnPool()
+
+		_, _, err := pool.dial("default/api-1", &failingDialer{err: fmt.Errorf("403 Forbidden")})
+		require.EqualError(t, err, "403 Forbidden")
+
+		dialer := &countingDialer{}
+		conn, _, err := pool.dial("default/api-1", dialer, portforward.PortForwardProtocolV1Name)
+		require.NoError(t, err)
+		assert.Equal(t, 1, dialer.count())
+		require.NoError(t, conn.Close())
+	})
+}
+
+func TestPortForwarder_PortForwardAPod_connectionPooling(t *testing.T) {
+	var mx sync.Mutex
+	upgrades := 0
+	srv := (&websocketPodServer{
+		podExists: func(_ string) bool {
+			mx.Lock()
+			defer mx.Unlock()
+			upgrades++
+			return true
+		},
+	}).start(t)
+
+	pod := readyPod("api-1")
+	pl := newMockPodProvider(t)
+	pl.EXPECT().getPod(mock.Anything, "default", "api-1").Times(3).Return(&pod, nil)
+
+	pf := withCoreClient(t, &PortForwarder{
+		listenerProvider: newNetListenerProvider("tcp"),
+		podProvider:      pl,
+		forwarder:        &streamForwarder{},
+		restCfg:          &rest.Config{Host: srv.URL, BearerToken: "token"},
+		transport:        TransportWebSocket,
+	})
+	WithConnectionPooling()(pf)
+
+	var processes []*PortForwardProcess
+	for _, port := range []uint{8080, 9090} {
+		process, err := pf.PortForwardAPod(context.TODO(), &TargetPod{
+			Name:           "api-1",
+			Port:           port,
+			LocalAddresses: []string{"127.0.0.1"},
+		})
+		require.NoError(t, err)
+		processes = append(processes, process)
+
+		select {
+		case <-process.Started():
+		case <-time.After(5 * time.Second):
+			t.Fatal("process did not start")
+		}
+	}
+
+	conn, err := pf.DialContext(context.TODO(), &TargetPod{Name: "api-1"}, 7070)
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	resp := make([]byte, len("7070:ping"))
+	_, err = io.ReadFull(conn, resp)
+	require.NoError(t, err)
+	assert.Equal(t, "7070:ping", string(resp))
+	require.NoError(t, conn.Close())
+
+	for i, port := range []uint{8080, 9090} {
+		conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", processes[i].Port))
+		require.NoError(t, err)
+
+		_, err = conn.Write([]byte("ping"))
+		require.NoError(t, err)
+		require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+		resp, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		assert.Equal(t, fmt.Sprintf("%d:ping", port), string(resp))
+		require.NoError(t, conn.Close())
+	}
+
+	processes[0].Stop()
+	assert.NoError(t, processes[0].Err())
+	processes[1].Stop()
+	assert.NoError(t, processes[1].Err())
+
+	mx.Lock()
+	defer mx.Unlock()
+	assert.Equal(t, 1, upgrades)
+}
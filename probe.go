@@ -0,0 +1,192 @@
+package portforwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+const (
+	defaultProbePeriod  = 500 * time.Millisecond
+	defaultProbeTimeout = 30 * time.Second
+	// tcpProbeSettleTime is how long the probed connection has to stay open,
+	// the local listener always accepts, but the pod closes the stream when its port is not open
+	tcpProbeSettleTime = 250 * time.Millisecond
+)
+
+// ReadinessProbe checks the first forwarded port through the tunnel before the process is started,
+// the local connection is accepted even when the container is not listening yet.
+// The TCP connection is checked when neither HTTPGet nor Func is set.
+type ReadinessProbe struct {
+	// HTTPGet - optional, the port is ready when the GET request returns the expected status
+	HTTPGet *HTTPGetProbe
+	// Func - optional custom check of the local endpoint of the forwarded port,
+	// network is tcp or unix and address is host:port or the socket path
+	Func func(ctx context.Context, network, address string) error
+	// Period - optional period between the checks, 500ms by default
+	Period time.Duration
+	// Timeout - optional time for the probe to pass, the process is stopped with ErrNotReady after it, 30s by default
+	Timeout time.Duration
+}
+
+// HTTPGetProbe checks the HTTP endpoint of the forwarded port
+type HTTPGetProbe struct {
+	// Path of the request, / by default
+	Path string
+	// ExpectedStatus of the response, 200 by default
+	ExpectedStatus int
+}
+
+func (r *ReadinessProbe) period() time.Duration {
+	if r.Period <= 0 {
+		return defaultProbePeriod
+	}
+	return r.Period
+}
+
+func (r *ReadinessProbe) timeout() time.Duration {
+	if r.Timeout <= 0 {
+		return defaultProbeTimeout
+	}
+	return r.Timeout
+}
+
+// wait checks the endpoint until the probe passes, times out or the cancel channel is closed
+func (r *ReadinessProbe) wait(cancelCh <-chan struct{}, network, address string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-cancelCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	ticker := time.NewTicker(r.period())
+	defer ticker.Stop()
+
+	var lastErr error
+	for {
+		err := r.check(ctx, network, address)
+		if err == nil {
+			return nil
+		}
+
+		// the check interrupted by the timeout says less than the previous failure
+		if lastErr == nil || !isTimeout(ctx, err) {
+			lastErr = err
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: probe did not pass in %s: %s", ErrNotReady, r.timeout(), lastErr.Error())
+		case <-ticker.C:
+		}
+	}
+}
+
+func isTimeout(ctx context.Context, err error) bool {
+	var netErr net.Error
+	return ctx.Err() != nil || errors.As(err, &netErr) && netErr.Timeout()
+}
+
+func (r *ReadinessProbe) check(ctx context.Context, network, address string) error {
+	switch {
+	case r.Func != nil:
+		return r.Func(ctx, network, address)
+	case r.HTTPGet != nil:
+		return r.HTTPGet.check(ctx, network, address)
+	default:
+		return checkTCP(ctx, network, address)
+	}
+}
+
+func checkTCP(ctx context.Context, network, address string) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, network, address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SetReadDeadline(time.Now().Add(tcpProbeSettleTime)); err != nil {
+		return err
+	}
+
+	// the connection is open when the pod either sends something first or keeps silent
+	_, err = conn.Read(make([]byte, 1))
+	if err == nil || errors.Is(err, os.ErrDeadlineExceeded) {
+		return nil
+	}
+
+	if errors.Is(err, io.EOF) {
+		return errors.New("connection closed by the pod")
+	}
+	return err
+}
+
+func (p *HTTPGetProbe) check(ctx context.Context, network, address string) error {
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, address)
+		},
+		DisableKeepAlives: true,
+	}}
+
+	host := address
+	if network == "unix" {
+		host = "localhost"
+	}
+
+	path := p.Path
+	if path == "" {
+		path = "/"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	expected := p.ExpectedStatus
+	if expected == 0 {
+		expected = http.StatusOK
+	}
+
+	if resp.StatusCode != expected {
+		return fmt.Errorf("GET %s returned %d, expected %d", path, resp.StatusCode, expected)
+	}
+	return nil
+}
+
+// probeEndpoint is the local endpoint of the forwarded port to probe,
+// the unspecified addresses like 0.0.0.0 are probed on the loopback
+func probeEndpoint(port *forwardedPort) (network, address string) {
+	switch addr := port.listeners[0].Addr().(type) {
+	case *net.UnixAddr:
+		return "unix", addr.Name
+	case *net.TCPAddr:
+		ip := addr.IP
+		if ip == nil || ip.IsUnspecified() {
+			ip = net.IPv4(127, 0, 0, 1)
+		}
+		return "tcp", net.JoinHostPort(ip.String(), fmt.Sprint(addr.Port))
+	default:
+		return addr.Network(), addr.String()
+	}
+}
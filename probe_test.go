@@ -0,0 +1,357 @@
+package portforwarder
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/rest"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// podBehindTunnel handles the forwarded connections like a pod would,
+// the first closedConns connections are closed as if the container was not listening yet
+func podBehindTunnel(t *testing.T, closedConns int, handle func(conn net.Conn)) *mockPortForwarder {
+	t.Helper()
+
+	f := newMockPortForwarder(t)
+	f.EXPECT().
+		forward(mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		RunAndReturn(func(_ httpstream.Dialer, _ []*forwardedPort, conns <-chan *acceptedConn, _ connHooks, stopCh <-chan struct{}, readyCh chan struct{}) error {
+			close(readyCh)
+			for {
+				select {
+				case <-stopCh:
+					return nil
+				case c := <-conns:
+					if closedConns > 0 {
+						closedConns--
+						_ = c.conn.Close()
+						continue
+					}
+					go handle(c.conn)
+				}
+			}
+		}).
+		Times(1)
+
+	return f
+}
+
+func forwardWithProbe(t *testing.T, f portForwarder, probe *ReadinessProbe) (*PortForwardProcess, *eventRecorder) {
+	t.Helper()
+
+	pod := readyPod("api-1")
+	pl := newMockPodProvider(t)
+	pl.EXPECT().getPod(context.TODO(), "default", "api-1").Times(1).Return(&pod, nil)
+
+	pf := withCoreClient(t, &PortForwarder{
+		listenerProvider: newNetListenerProvider("tcp"),
+		podProvider:      pl,
+		forwarder:        f,
+		restCfg:          &rest.Config{},
+	})
+
+	recorder := &eventRecorder{}
+	process, err := pf.PortForwardAPod(context.TODO(), &TargetPod{
+		Port:           8080,
+		Name:           "api-1",
+		LocalAddresses: []string{"0.0.0.0"},
+		Readiness:      probe,
+		OnEvent:        recorder.handle,
+	})
+	require.NoError(t, err)
+
+	return process, recorder
+}
+
+func keepOpen(conn net.Conn) {
+	_, _ = io.Copy(io.Discard, conn)
+}
+
+func TestReadinessProbe_tcp(t *testing.T) {
+	process, recorder := forwardWithProbe(t, podBehindTunnel(t, 2, keepOpen), &ReadinessProbe{Period: 10 * time.Millisecond})
+
+	select {
+	case <-process.Started():
+	case <-time.After(5 * time.Second):
+		t.Fatal("process did not start")
+	}
+
+	process.Stop()
+	<-process.Finished()
+	assert.NoError(t, process.Err())
+
+	events := recorder.recorded()
+	assert.IsType(t, Ready{}, events[len(events)-2])
+}
+
+func TestReadinessProbe_httpGet(t *testing.T) {
+	statuses := make(chan int, 3)
+	statuses <- http.StatusServiceUnavailable
+	statuses <- http.StatusOK
+	statuses <- http.StatusNoContent
+
+	paths := make(chan string, 3)
+	handle := func(conn net.Conn) {
+		defer conn.Close()
+		req, err := http.ReadRequest(bufio.NewReader(conn))
+		if err != nil {
+			return
+		}
+		paths <- req.URL.Path
+
+		resp := &http.Response{StatusCode: <-statuses, ProtoMajor: 1, ProtoMinor: 1, Close: true}
+		_ = resp.Write(conn)
+	}
+
+	process, _ := forwardWithProbe(t, podBehindTunnel(t, 1, handle), &ReadinessProbe{
+		HTTPGet: &HTTPGetProbe{Path: "/healthz", ExpectedStatus: http.StatusNoContent},
+		Period:  10 * time.Millisecond,
+	})
+
+	select {
+	case <-process.Started():
+	case <-time.After(5 * time.Second):
+		t.Fatal("process did not start")
+	}
+
+	process.Stop()
+	assert.NoError(t, process.Err())
+	assert.Len(t, paths, 3)
+	assert.Equal(t, "/healthz", <-paths)
+}
+
+func TestReadinessProbe_func(t *testing.T) {
+	var probed []string
+	process, _ := forwardWithProbe(t, podBehindTunnel(t, 0, keepOpen), &ReadinessProbe{
+		Func: func(_ context.Context, network, address string) error {
+			probed = append(probed, network+" "+address)
+			if len(probed) < 2 {
+				return fmt.Errorf("not yet")
+			}
+			return nil
+		},
+		Period: 10 * time.Millisecond,
+	})
+
+	select {
+	case <-process.Started():
+	case <-time.After(5 * time.Second):
+		t.Fatal("process did not start")
+	}
+
+	process.Stop()
+	require.Len(t, probed, 2)
+	assert.Equal(t, fmt.Sprintf("tcp 127.0.0.1:%d", process.Port), probed[0])
+}
+
+func TestReadinessProbe_timeout(t *testing.T) {
+	process, recorder := forwardWithProbe(t, podBehindTunnel(t, 1000, keepOpen), &ReadinessProbe{
+		Period:  10 * time.Millisecond,
+		Timeout: 200 * time.Millisecond,
+	})
+
+	select {
+	case <-process.Finished():
+	case <-time.After(5 * time.Second):
+		t.Fatal("process did not finish")
+	}
+
+	require.ErrorIs(t, process.Err(), ErrNotReady)
+	assert.Contains(t, process.Err().Error(), "connection closed by the pod")
+
+	select {
+	case <-process.Started():
+		t.Fatal("process should not be started")
+	default:
+	}
+
+	for _, e := range recorder.recorded() {
+		assert.NotEqual(t, "Ready", fmt.Sprintf("%T", e))
+	}
+}
+
+// refusingPod answers like kubelet does while the container is not listening on the port yet,
+// the error streams of the first refused requests get the error and their data streams are closed
+func refusingPod(refused int) *websocketPodServer {
+	isRefused := func(s httpstream.Stream) bool {
+		requestID, _ := strconv.Atoi(s.Headers().Get(corev1.PortForwardRequestIDHeader))
+		return requestID < refused
+	}
+
+	return &websocketPodServer{
+		handleError: func(s httpstream.Stream) {
+			defer s.Close()
+			if isRefused(s) {
+				port := s.Headers().Get(corev1.PortHeader)
+				_, _ = fmt.Fprintf(s, "error forwarding port %s to pod: dial tcp4 127.0.0.1:%s: connect: connection refused", port, port)
+			}
+		},
+		handleData: func(s httpstream.Stream) {
+			if isRefused(s) {
+				_ = s.Close()
+				return
+			}
+			echoStream(s)
+		},
+	}
+}
+
+func TestReadinessProbe_refusedByPod(t *testing.T) {
+	srv := refusingPod(2).start(t)
+
+	pod := readyPod("api-1")
+	pl := newMockPodProvider(t)
+	pl.EXPECT().getPod(mock.Anything, "default", "api-1").Times(1).Return(&pod, nil)
+
+	pf := withCoreClient(t, &PortForwarder{
+		listenerProvider: newNetListenerProvider("tcp"),
+		podProvider:      pl,
+		forwarder:        &streamForwarder{},
+		restCfg:          &rest.Config{Host: srv.URL, BearerToken: "token"},
+		transport:        TransportWebSocket,
+	})
+
+	process, err := pf.PortForwardAPod(context.TODO(), &TargetPod{
+		Name:           "api-1",
+		Port:           8080,
+		LocalAddresses: []string{"127.0.0.1"},
+		Readiness:      &ReadinessProbe{Period: 10 * time.Millisecond},
+	})
+	require.NoError(t, err)
+	defer process.Stop()
+
+	select {
+	case <-process.Started():
+	case <-process.Finished():
+		t.Fatalf("process finished before it was started: %v", process.Err())
+	case <-time.After(5 * time.Second):
+		t.Fatal("process did not start")
+	}
+
+	// the same streaming connection forwards after the refused probes
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", process.Port))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "8080:ping", string(resp))
+}
+
+func TestReadinessProbe_failedAfterStarted(t *testing.T) {
+	var forwards atomic.Int32
+	passed := make(chan struct{})
+
+	f := newMockPortForwarder(t)
+	f.EXPECT().
+		forward(mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		RunAndReturn(func(_ httpstream.Dialer, _ []*forwardedPort, _ <-chan *acceptedConn, _ connHooks, _ <-chan struct{}, readyCh chan struct{}) error {
+			forwards.Add(1)
+			close(readyCh)
+			<-passed
+			return ErrLostConnection
+		}).
+		Times(1)
+	f.EXPECT().
+		forward(mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Run(func(httpstream.Dialer, []*forwardedPort, <-chan *acceptedConn, connHooks, <-chan struct{}, chan struct{}) {
+			forwards.Add(1)
+		}).
+		RunAndReturn(forwardUntilStopped).
+		Times(2)
+
+	pod := readyPod("api-1")
+	pl := newMockPodProvider(t)
+	pl.EXPECT().getPod(context.TODO(), "default", "api-1").Times(3).Return(&pod, nil)
+
+	pf := withCoreClient(t, &PortForwarder{
+		listenerProvider: newNetListenerProvider("tcp"),
+		podProvider:      pl,
+		forwarder:        f,
+		restCfg:          &rest.Config{},
+	})
+
+	recorder := &eventRecorder{}
+	process, err := pf.PortForwardAPod(context.TODO(), &TargetPod{
+		Port:           8080,
+		Name:           "api-1",
+		LocalAddresses: []string{"127.0.0.1"},
+		Reconnect:      &ReconnectPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+		Readiness: &ReadinessProbe{
+			Period:  10 * time.Millisecond,
+			Timeout: 100 * time.Millisecond,
+			Func: func(_ context.Context, _, _ string) error {
+				switch forwards.Load() {
+				case 1:
+					close(passed)
+				case 2:
+					return errors.New("warming up")
+				}
+				return nil
+			},
+		},
+		OnEvent: recorder.handle,
+	})
+	require.NoError(t, err)
+	defer process.Stop()
+
+	require.Eventually(t, func() bool {
+		return forwards.Load() == 3 && len(readyEvents(recorder)) == 2
+	}, 5*time.Second, 10*time.Millisecond)
+
+	select {
+	case <-process.Finished():
+		t.Fatalf("process is finished: %v", process.Err())
+	default:
+	}
+
+	var reconnects []Reconnecting
+	for _, e := range recorder.recorded() {
+		if r, ok := e.(Reconnecting); ok {
+			reconnects = append(reconnects, r)
+		}
+	}
+	require.Len(t, reconnects, 2)
+	assert.ErrorIs(t, reconnects[0].Err, ErrLostConnection)
+	assert.Equal(t, 2, reconnects[1].Attempt, "the failed probe counts as the reconnect attempt")
+	assert.ErrorIs(t, reconnects[1].Err, ErrNotReady)
+}
+
+func readyEvents(recorder *eventRecorder) []Ready {
+	var ready []Ready
+	for _, e := range recorder.recorded() {
+		if r, ok := e.(Ready); ok {
+			ready = append(ready, r)
+		}
+	}
+	return ready
+}
+
+func TestReadinessProbe_validation(t *testing.T) {
+	pf := &PortForwarder{restCfg: &rest.Config{}}
+
+	_, err := pf.PortForwardAPod(context.TODO(), &TargetPod{
+		Name:      "dns-1",
+		Port:      53,
+		UDP:       true,
+		Readiness: &ReadinessProbe{HTTPGet: &HTTPGetProbe{}},
+	})
+	require.ErrorIs(t, err, ErrTargetPodValidation)
+	assert.Contains(t, err.Error(), "only the custom readiness probe can check UDP")
+}
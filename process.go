@@ -2,28 +2,166 @@ package portforwarder
 
 import (
 	"context"
+	"fmt"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"net"
+	"strconv"
 	"sync"
+	"time"
 )
 
+// shutdownPollInterval is how often Shutdown checks whether the active connections are finished
+const shutdownPollInterval = 50 * time.Millisecond
+
+// forwardedPort is a remote port of the pod with the local listeners bound for it
+type forwardedPort struct {
+	local uint
+	// remote port of the current pod, it changes on reconnects when the named port resolves differently
+	remote uint
+	mx     sync.Mutex
+	// socket is the path of the local Unix socket, the local port is 0 then
+	socket    string
+	listeners []net.Listener
+}
+
+func newForwardedPort(listeners []net.Listener, remote uint) *forwardedPort {
+	port := &forwardedPort{
+		local:     addrPort(listeners[0].Addr()),
+		remote:    remote,
+		listeners: listeners,
+	}
+
+	if addr, ok := listeners[0].Addr().(*net.UnixAddr); ok {
+		port.socket = addr.Name
+	}
+
+	return port
+}
+
+func (p *forwardedPort) remotePort() uint {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return p.remote
+}
+
+func (p *forwardedPort) setRemotePort(remote uint) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	p.remote = remote
+}
+
+// localEndpoint is the local port or the path of the local Unix socket
+func (p *forwardedPort) localEndpoint() string {
+	if p.socket != "" {
+		return p.socket
+	}
+	return strconv.Itoa(int(p.local))
+}
+
+func (p *forwardedPort) String() string {
+	return fmt.Sprintf("%s -> %d", p.localEndpoint(), p.remotePort())
+}
+
+func closeListeners(ports []*forwardedPort) {
+	for _, port := range ports {
+		for _, l := range port.listeners {
+			_ = l.Close()
+		}
+	}
+}
+
+// acceptedConn is a local connection accepted for the forwarded port
+type acceptedConn struct {
+	conn net.Conn
+	port *forwardedPort
+	// span - optional span of the forwarded connection
+	span trace.Span
+	// release - optional callback of the process tracking the active connections
+	release func()
+	// probe - the connection was accepted while the readiness probe was running,
+	// the pod refusing it is not ready yet, so the streaming connection is kept
+	probe bool
+}
+
+func (c *acceptedConn) recordError(err error) {
+	if c.span != nil {
+		c.span.RecordError(err)
+		c.span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// done ends the span of the connection once it is handled
+func (c *acceptedConn) done() {
+	if c.span != nil {
+		c.span.End()
+	}
+	if c.release != nil {
+		c.release()
+	}
+}
+
 type PortForwardProcess struct {
-	Port       uint
-	err        error
-	startedCh  chan struct{}
-	finishedCh chan struct{}
-	stopCh     chan struct{}
-	stopper    sync.Once
-	mx         sync.Mutex
-	wg         sync.WaitGroup
+	// Port is the local port forwarded to the first of the target ports
+	Port uint
+	// Socket is the path of the local Unix socket forwarded to the first of the target ports,
+	// Port is 0 then
+	Socket  string
+	ports   []*forwardedPort
+	connCh  chan *acceptedConn
+	onEvent EventHandler
+	// events handles the events off the forwarding goroutines, which Stop waits for
+	events  eventQueue
+	metrics *forwardMetrics
+	ctx     context.Context
+	tracer  trace.Tracer
+	podName string
+	// probing is set while the readiness probe is running
+	probing bool
+	// activeConns is the number of the accepted connections not handled completely yet
+	activeConns int
+	err         error
+	startedCh   chan struct{}
+	finishedCh  chan struct{}
+	stopCh      chan struct{}
+	stopper     sync.Once
+	starter     sync.Once
+	mx          sync.Mutex
+	wg          sync.WaitGroup
+	// accepting waits for the goroutines accepting the local connections
+	accepting sync.WaitGroup
 }
 
-func newPortForwardProcess(ctx context.Context, port uint) *PortForwardProcess {
+func newPortForwardProcess(
+	ctx context.Context,
+	ports []*forwardedPort,
+	onEvent EventHandler,
+	tracer trace.Tracer,
+) *PortForwardProcess {
+	if tracer == nil {
+		tracer = newTracer(nil)
+	}
+
 	p := &PortForwardProcess{
-		Port:       port,
+		Port:       ports[0].local,
+		Socket:     ports[0].socket,
+		ports:      ports,
+		connCh:     make(chan *acceptedConn),
+		onEvent:    onEvent,
+		ctx:        ctx,
+		tracer:     tracer,
 		startedCh:  make(chan struct{}),
 		finishedCh: make(chan struct{}),
 		stopCh:     make(chan struct{}),
 	}
 
+	for _, port := range ports {
+		for _, l := range port.listeners {
+			p.accepting.Add(1)
+			go p.acceptConnections(port, l)
+		}
+	}
+
 	go func() {
 		select {
 		case <-ctx.Done():
@@ -38,30 +176,207 @@ func newPortForwardProcess(ctx context.Context, port uint) *PortForwardProcess {
 	return p
 }
 
+// Stop stops the process and waits for the forwarding to end,
+// Finished is closed once the Stopped event is handled. It is safe to call from the event handler.
 func (p *PortForwardProcess) Stop() {
+	stopped := false
 	p.stopper.Do(func() {
 		close(p.stopCh)
+		closeListeners(p.ports)
+		p.accepting.Wait()
 		p.wg.Wait()
+		stopped = true
+	})
+	if !stopped {
+		return
+	}
+
+	stoppedEvent := Stopped{Err: p.Err()}
+	p.events.push(func() {
+		p.onEvent.emit(stoppedEvent)
 		close(p.finishedCh)
 	})
 }
 
+// emit queues the event, so it is handled off the forwarding goroutines
+func (p *PortForwardProcess) emit(e Event) {
+	p.events.push(func() {
+		p.onEvent.emit(e)
+	})
+}
+
+// Shutdown stops accepting new local connections and waits for the active ones to finish
+// until the context is done, then stops the process cutting the rest of them like Stop does.
+// It returns the number of the connections cut and the error of the context when any were cut.
+// The UDP peers are cut immediately, since they are bound to the local listener.
+func (p *PortForwardProcess) Shutdown(ctx context.Context) (int, error) {
+	closeListeners(p.ports)
+
+	// the connections accepted before the listeners got closed are counted once they are handed over
+	acceptedCh := make(chan struct{})
+	go func() {
+		p.accepting.Wait()
+		close(acceptedCh)
+	}()
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	accepted := false
+	for {
+		if accepted && p.countActiveConns() == 0 {
+			p.Stop()
+			return 0, nil
+		}
+
+		select {
+		case <-acceptedCh:
+			accepted, acceptedCh = true, nil
+		case <-ticker.C:
+		case <-p.finishedCh:
+			return 0, nil
+		case <-ctx.Done():
+			cut := p.countActiveConns()
+			p.Stop()
+			if cut > 0 {
+				return cut, ctx.Err()
+			}
+			return 0, nil
+		}
+	}
+}
+
 // Started signals that port forward has started
 func (p *PortForwardProcess) Started() <-chan struct{} {
 	return p.startedCh
 }
 
-// Finished signals that port forward has finished
+// Finished signals that port forward has finished and the Stopped event is handled
 func (p *PortForwardProcess) Finished() <-chan struct{} {
 	return p.finishedCh
 }
 
+// Ports returns the mapping of the remote pod ports to the local ports
+func (p *PortForwardProcess) Ports() map[uint]uint {
+	ports := make(map[uint]uint, len(p.ports))
+	for _, port := range p.ports {
+		ports[port.remotePort()] = port.local
+	}
+	return ports
+}
+
+func (p *PortForwardProcess) forwardedPorts() []string {
+	ports := make([]string, len(p.ports))
+	for i, port := range p.ports {
+		ports[i] = fmt.Sprintf("%s:%d", port.localEndpoint(), port.remotePort())
+	}
+	return ports
+}
+
+// acceptConnections hands the accepted local connections over to the forwarder
+// until the listener gets closed on stop
+func (p *PortForwardProcess) acceptConnections(port *forwardedPort, l net.Listener) {
+	defer p.accepting.Done()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+
+		p.emit(ConnectionAccepted{
+			PodName:    p.currentPodName(),
+			LocalPort:  port.local,
+			RemotePort: port.remotePort(),
+			RemoteAddr: conn.RemoteAddr().String(),
+		})
+
+		_, span := p.tracer.Start(p.ctx, "portforwarder.connection", trace.WithAttributes(
+			podKey.String(p.currentPodName()),
+			localPortKey.Int64(int64(port.local)),
+			remotePortKey.Int64(int64(port.remotePort())),
+		))
+
+		c := &acceptedConn{conn: conn, port: port, span: span, release: p.trackConn(), probe: p.isProbing()}
+		select {
+		case p.connCh <- c:
+		case <-p.stopCh:
+			_ = conn.Close()
+			c.done()
+			return
+		}
+	}
+}
+
+// trackConn counts the connection as active until the returned release is called
+func (p *PortForwardProcess) trackConn() func() {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	p.activeConns++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			p.mx.Lock()
+			defer p.mx.Unlock()
+			p.activeConns--
+		})
+	}
+}
+
+func (p *PortForwardProcess) countActiveConns() int {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return p.activeConns
+}
+
 func (p *PortForwardProcess) Err() error {
 	p.mx.Lock()
 	defer p.mx.Unlock()
 	return p.err
 }
 
+// currentPodName is the name of the pod being forwarded to, it changes on reconnects
+func (p *PortForwardProcess) currentPodName() string {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return p.podName
+}
+
+func (p *PortForwardProcess) setPodName(podName string) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	p.podName = podName
+}
+
+func (p *PortForwardProcess) setProbing(probing bool) {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	p.probing = probing
+}
+
+func (p *PortForwardProcess) isProbing() bool {
+	p.mx.Lock()
+	defer p.mx.Unlock()
+	return p.probing
+}
+
+// setRemotePorts updates the remote ports to the ones resolved for the newly selected pod,
+// the local ports stay the same, so the pod has to resolve to as many ports as are forwarded
+func (p *PortForwardProcess) setRemotePorts(podName string, remotePorts []uint) error {
+	if len(remotePorts) != len(p.ports) {
+		return fmt.Errorf(
+			"%w: pod %s resolves to %d ports, %d are forwarded",
+			ErrPodPortNotFound, podName, len(remotePorts), len(p.ports),
+		)
+	}
+
+	for i, port := range p.ports {
+		port.setRemotePort(remotePorts[i])
+	}
+	return nil
+}
+
 func (p *PortForwardProcess) setError(err error) {
 	p.mx.Lock()
 	defer p.mx.Unlock()
@@ -69,5 +384,140 @@ func (p *PortForwardProcess) setError(err error) {
 }
 
 func (p *PortForwardProcess) markAsReady() {
-	close(p.startedCh)
+	p.starter.Do(func() {
+		close(p.startedCh)
+	})
+}
+
+// forwardAttempt is a single connection to the pod, the process connects again on reconnects
+type forwardAttempt struct {
+	// readyCh is closed by the forwarder once the connection is upgraded
+	readyCh chan struct{}
+	// stopCh stops the forwarder of the attempt, it is closed when the process is stopped or the attempt is aborted
+	stopCh chan struct{}
+	// doneCh is closed when the forwarder of the attempt returns
+	doneCh  chan struct{}
+	stopper sync.Once
+	mx      sync.Mutex
+	err     error
+}
+
+func (p *PortForwardProcess) newForwardAttempt() *forwardAttempt {
+	a := &forwardAttempt{
+		readyCh: make(chan struct{}),
+		stopCh:  make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+
+	go func() {
+		select {
+		case <-p.stopCh:
+			a.abort(nil)
+		case <-a.doneCh:
+		}
+	}()
+
+	return a
+}
+
+// abort stops the forwarder of the attempt, the error is the reason the attempt failed with
+func (a *forwardAttempt) abort(err error) {
+	a.stopper.Do(func() {
+		a.mx.Lock()
+		a.err = err
+		a.mx.Unlock()
+		close(a.stopCh)
+	})
+}
+
+func (a *forwardAttempt) abortErr() error {
+	a.mx.Lock()
+	defer a.mx.Unlock()
+	return a.err
+}
+
+func (a *forwardAttempt) done() {
+	close(a.doneCh)
+}
+
+// markAsReadyOn marks the process as ready once the forwarder of the attempt is ready and the optional probe passes,
+// it is called for every connection attempt, but the process is started only once.
+// It returns without marking when the attempt is done first, the probe is cancelled with the attempt as well.
+// The process is stopped with the error when the probe does not pass in time before the process is started,
+// after that the attempt is aborted instead, so the pod is reconnected like after a lost connection.
+func (p *PortForwardProcess) markAsReadyOn(attempt *forwardAttempt, ready Ready, probe *ReadinessProbe) {
+	select {
+	case <-attempt.readyCh:
+	case <-attempt.doneCh:
+		return
+	case <-p.stopCh:
+		return
+	}
+
+	if probe != nil {
+		network, address := probeEndpoint(p.ports[0])
+		_, span := p.tracer.Start(p.ctx, "portforwarder.readiness", trace.WithAttributes(
+			podKey.String(ready.PodName),
+			remotePortKey.Int64(int64(p.ports[0].remotePort())),
+		))
+		p.setProbing(true)
+		err := probe.wait(attempt.doneCh, network, address)
+		p.setProbing(false)
+		endSpan(span, err)
+		if err != nil {
+			switch {
+			case p.isStopped() || isClosed(attempt.doneCh):
+			case isClosed(p.startedCh):
+				attempt.abort(err)
+			default:
+				p.setError(err)
+				p.Stop()
+			}
+			return
+		}
+	}
+
+	p.emit(ready)
+	p.markAsReady()
+}
+
+// processHooks reports the forwarded connections of the process,
+// the forwarder gets it instead of the process, so the mutable process is never shared as an argument
+type processHooks struct {
+	process *PortForwardProcess
+}
+
+func (p *PortForwardProcess) hooks() connHooks {
+	return &processHooks{process: p}
+}
+
+func (h *processHooks) streamError(c *acceptedConn, err error) {
+	c.recordError(err)
+	h.process.emit(StreamError{
+		PodName:    h.process.currentPodName(),
+		LocalPort:  c.port.local,
+		RemotePort: c.port.remotePort(),
+		Err:        err,
+	})
+}
+
+func (h *processHooks) received(c *acceptedConn, n int) {
+	h.process.metrics.bytesReceived(h.process.currentPodName(), c.port.remotePort(), n)
+}
+
+func (h *processHooks) sent(c *acceptedConn, n int) {
+	h.process.metrics.bytesSent(h.process.currentPodName(), c.port.remotePort(), n)
+}
+
+func (p *PortForwardProcess) isStopped() bool {
+	return isClosed(p.stopCh)
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
 }
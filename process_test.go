@@ -0,0 +1,186 @@
+package portforwarder
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"io"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/rest"
+	"net"
+	"testing"
+	"time"
+)
+
+// forwardToSlowPod starts forwarding to the pod which answers the data streams with done
+// once the request is read and the answer is released
+func forwardToSlowPod(t *testing.T, release <-chan struct{}) *PortForwardProcess {
+	t.Helper()
+
+	srv := (&websocketPodServer{
+		handleData: func(s httpstream.Stream) {
+			defer s.Close()
+			if _, err := io.ReadAll(s); err != nil {
+				return
+			}
+
+			<-release
+			_, _ = s.Write([]byte("done"))
+		},
+	}).start(t)
+
+	pod := readyPod("db-0")
+	pl := newMockPodProvider(t)
+	pl.EXPECT().getPod(mock.Anything, "default", "db-0").Times(1).Return(&pod, nil)
+
+	pf := withCoreClient(t, &PortForwarder{
+		listenerProvider: newNetListenerProvider("tcp"),
+		podProvider:      pl,
+		forwarder:        &streamForwarder{},
+		restCfg:          &rest.Config{Host: srv.URL, BearerToken: "token"},
+		transport:        TransportWebSocket,
+	})
+
+	process, err := pf.PortForwardAPod(context.TODO(), &TargetPod{
+		Name:           "db-0",
+		Port:           5432,
+		LocalAddresses: []string{"127.0.0.1"},
+	})
+	require.NoError(t, err)
+
+	select {
+	case <-process.Started():
+	case <-time.After(5 * time.Second):
+		t.Fatal("process did not start")
+	}
+
+	return process
+}
+
+// sendRequest sends the request and waits for it to become an active connection of the process
+func sendRequest(t *testing.T, process *PortForwardProcess) net.Conn {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", process.Port))
+	require.NoError(t, err)
+
+	_, err = conn.Write([]byte("migrate"))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+	require.Eventually(t, func() bool {
+		return process.countActiveConns() == 1
+	}, 5*time.Second, 10*time.Millisecond)
+
+	return conn
+}
+
+func TestPortForwardProcess_Shutdown(t *testing.T) {
+	t.Run("active connections are drained", func(t *testing.T) {
+		release := make(chan struct{})
+		process := forwardToSlowPod(t, release)
+		conn := sendRequest(t, process)
+
+		type result struct {
+			cut int
+			err error
+		}
+		resultCh := make(chan result, 1)
+		go func() {
+			cut, err := process.Shutdown(context.Background())
+			resultCh <- result{cut: cut, err: err}
+		}()
+
+		require.Eventually(t, func() bool {
+			_, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", process.Port))
+			return err != nil
+		}, 5*time.Second, 10*time.Millisecond, "new connections are not accepted")
+
+		select {
+		case <-process.Finished():
+			t.Fatal("process is finished before the connection")
+		default:
+		}
+
+		close(release)
+		resp, err := io.ReadAll(conn)
+		require.NoError(t, err)
+		assert.Equal(t, "done", string(resp))
+		require.NoError(t, conn.Close())
+
+		r := <-resultCh
+		require.NoError(t, r.err)
+		assert.Equal(t, 0, r.cut)
+		<-process.Finished()
+		assert.NoError(t, process.Err())
+	})
+
+	t.Run("connections are cut after the deadline", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+
+		process := forwardToSlowPod(t, release)
+		conn := sendRequest(t, process)
+		defer conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		cut, err := process.Shutdown(ctx)
+		require.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Equal(t, 1, cut)
+
+		<-process.Finished()
+		resp, _ := io.ReadAll(conn)
+		assert.Empty(t, resp)
+	})
+
+	t.Run("idle process is stopped right away", func(t *testing.T) {
+		process := forwardToSlowPod(t, nil)
+
+		cut, err := process.Shutdown(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 0, cut)
+		<-process.Finished()
+
+		cut, err = process.Shutdown(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, 0, cut)
+	})
+}
+
+func TestPortForwardProcess_markAsReadyOn_attemptDone(t *testing.T) {
+	ports := []*forwardedPort{newForwardedPort(newTestListeners(3999), 5432)}
+	process := newPortForwardProcess(context.TODO(), ports, nil, nil)
+	defer process.Stop()
+
+	attempt := process.newForwardAttempt()
+	returned := make(chan struct{})
+	go func() {
+		defer close(returned)
+		process.markAsReadyOn(attempt, Ready{PodName: "db-0"}, nil)
+	}()
+
+	attempt.done()
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("readiness of the failed attempt is still waited for")
+	}
+	assert.False(t, isClosed(process.Started()))
+}
+
+func TestPortForwardProcess_setRemotePorts(t *testing.T) {
+	ports := []*forwardedPort{newForwardedPort(newTestListeners(3999), 8080)}
+	process := newPortForwardProcess(context.TODO(), ports, nil, nil)
+	defer process.Stop()
+
+	require.NoError(t, process.setRemotePorts("api-2", []uint{9090}))
+	assert.Equal(t, map[uint]uint{9090: 3999}, process.Ports())
+
+	err := process.setRemotePorts("api-3", []uint{9090, 9091})
+	require.ErrorIs(t, err, ErrPodPortNotFound)
+	assert.Equal(t, map[uint]uint{9090: 3999}, process.Ports())
+}
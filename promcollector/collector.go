@@ -0,0 +1,212 @@
+// Package promcollector exposes the metrics of the port forward processes as a Prometheus collector
+//
+//	collector := promcollector.New()
+//	prometheus.MustRegister(collector)
+//	pf, err := portforwarder.NewPortForwarder(connector, portforwarder.WithMetrics(collector))
+package promcollector
+
+import (
+	"github.com/denismitr/portforwarder"
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const namespace = "portforwarder"
+
+var _ portforwarder.Metrics = (*Collector)(nil)
+
+// Collector is the prometheus.Collector and the portforwarder.Metrics at the same time
+type Collector struct {
+	activeForwards      *prometheus.GaugeVec
+	connectionsAccepted *prometheus.CounterVec
+	receivedBytes       *prometheus.CounterVec
+	sentBytes           *prometheus.CounterVec
+	streamErrors        *prometheus.CounterVec
+	reconnects          *prometheus.CounterVec
+	timeToReady         *prometheus.HistogramVec
+	staleTTL            time.Duration
+	// mx guards active, the number of the active forwards per pod of all the processes,
+	// and removed, the time since none of them forwards to the pod anymore
+	mx      sync.Mutex
+	active  map[podKey]int
+	removed map[podKey]time.Time
+}
+
+// DefaultStaleTTL is how long the cumulative series of a pod no process forwards to anymore are kept
+const DefaultStaleTTL = 10 * time.Minute
+
+// Option configures the collector
+type Option func(c *Collector)
+
+// WithStaleTTL sets how long the cumulative series of a pod are kept after no process forwards to it anymore,
+// so they are scraped with their final values before they are deleted
+func WithStaleTTL(ttl time.Duration) Option {
+	return func(c *Collector) {
+		c.staleTTL = ttl
+	}
+}
+
+type podKey struct {
+	namespace, pod string
+}
+
+// New creates the collector, it has to be registered in the Prometheus registry
+func New(opts ...Option) *Collector {
+	portLabels := []string{"namespace", "pod", "port"}
+	podLabels := []string{"namespace", "pod"}
+
+	c := &Collector{
+		activeForwards: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "active_forwards",
+			Help:      "Number of the established forwards to the pod port.",
+		}, portLabels),
+		connectionsAccepted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "connections_accepted_total",
+			Help:      "Number of the accepted local connections.",
+		}, portLabels),
+		receivedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "received_bytes_total",
+			Help:      "Number of the bytes received from the pod.",
+		}, portLabels),
+		sentBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "sent_bytes_total",
+			Help:      "Number of the bytes sent to the pod.",
+		}, portLabels),
+		streamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "stream_errors_total",
+			Help:      "Number of the failed connections, port is 0 when the connection to the pod failed.",
+		}, portLabels),
+		reconnects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "reconnects_total",
+			Help:      "Number of the reconnect attempts after the connection to the pod was lost.",
+		}, podLabels),
+		timeToReady: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "time_to_ready_seconds",
+			Help:      "Time from the start of the port forward process until it is started.",
+			Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+		}, podLabels),
+		staleTTL: DefaultStaleTTL,
+		active:   make(map[podKey]int),
+		removed:  make(map[podKey]time.Time),
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+func (c *Collector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		c.activeForwards,
+		c.connectionsAccepted,
+		c.receivedBytes,
+		c.sentBytes,
+		c.streamErrors,
+		c.reconnects,
+		c.timeToReady,
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, collector := range c.collectors() {
+		collector.Describe(ch)
+	}
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.deleteStale()
+	for _, collector := range c.collectors() {
+		collector.Collect(ch)
+	}
+}
+
+func (c *Collector) ForwardActive(namespace, pod string, port uint, delta int) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+	key := podKey{namespace: namespace, pod: pod}
+	c.active[key] += delta
+	if delta > 0 {
+		delete(c.removed, key)
+	}
+	c.activeForwards.WithLabelValues(namespace, pod, formatPort(port)).Add(float64(delta))
+}
+
+func (c *Collector) ConnectionAccepted(namespace, pod string, port uint) {
+	c.connectionsAccepted.WithLabelValues(namespace, pod, formatPort(port)).Inc()
+}
+
+func (c *Collector) BytesReceived(namespace, pod string, port uint, n int) {
+	c.receivedBytes.WithLabelValues(namespace, pod, formatPort(port)).Add(float64(n))
+}
+
+func (c *Collector) BytesSent(namespace, pod string, port uint, n int) {
+	c.sentBytes.WithLabelValues(namespace, pod, formatPort(port)).Add(float64(n))
+}
+
+func (c *Collector) StreamError(namespace, pod string, port uint) {
+	c.streamErrors.WithLabelValues(namespace, pod, formatPort(port)).Inc()
+}
+
+func (c *Collector) Reconnect(namespace, pod string) {
+	c.reconnects.WithLabelValues(namespace, pod).Inc()
+}
+
+func (c *Collector) TimeToReady(namespace, pod string, d time.Duration) {
+	c.timeToReady.WithLabelValues(namespace, pod).Observe(d.Seconds())
+}
+
+// ForwardRemoved deletes the active forwards of the pod unless another process still forwards to it,
+// the cumulative series of the pod are deleted once they are stale for the TTL
+func (c *Collector) ForwardRemoved(namespace, pod string) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	key := podKey{namespace: namespace, pod: pod}
+	if c.active[key] > 0 {
+		return
+	}
+	delete(c.active, key)
+
+	c.activeForwards.DeletePartialMatch(prometheus.Labels{"namespace": namespace, "pod": pod})
+	if _, ok := c.removed[key]; !ok {
+		c.removed[key] = time.Now()
+	}
+}
+
+// deleteStale deletes the cumulative series of the pods no process forwarded to for the TTL
+func (c *Collector) deleteStale() {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	for key, removedAt := range c.removed {
+		if time.Since(removedAt) < c.staleTTL {
+			continue
+		}
+		delete(c.removed, key)
+
+		labels := prometheus.Labels{"namespace": key.namespace, "pod": key.pod}
+		c.connectionsAccepted.DeletePartialMatch(labels)
+		c.receivedBytes.DeletePartialMatch(labels)
+		c.sentBytes.DeletePartialMatch(labels)
+		c.streamErrors.DeletePartialMatch(labels)
+		c.reconnects.DeletePartialMatch(labels)
+		c.timeToReady.DeletePartialMatch(labels)
+	}
+}
+
+func formatPort(port uint) string {
+	return strconv.FormatUint(uint64(port), 10)
+}
@@ -0,0 +1,154 @@
+package promcollector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollector(t *testing.T) {
+	c := New()
+
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(c))
+
+	c.ForwardActive("shop", "api-1", 8080, 1)
+	c.ForwardActive("shop", "api-1", 8080, -1)
+	c.ForwardActive("shop", "api-2", 8080, 1)
+	c.ConnectionAccepted("shop", "api-2", 8080)
+	c.BytesSent("shop", "api-2", 8080, 4)
+	c.BytesReceived("shop", "api-2", 8080, 9)
+	c.BytesReceived("shop", "api-2", 8080, 9)
+	c.StreamError("shop", "api-1", 0)
+	c.Reconnect("shop", "api-1")
+	c.TimeToReady("shop", "api-1", 300*time.Millisecond)
+
+	expected := `
+# HELP portforwarder_active_forwards Number of the established forwards to the pod port.
+# TYPE portforwarder_active_forwards gauge
+portforwarder_active_forwards{namespace="shop",pod="api-1",port="8080"} 0
+portforwarder_active_forwards{namespace="shop",pod="api-2",port="8080"} 1
+# HELP portforwarder_connections_accepted_total Number of the accepted local connections.
+# TYPE portforwarder_connections_accepted_total counter
+portforwarder_connections_accepted_total{namespace="shop",pod="api-2",port="8080"} 1
+# HELP portforwarder_received_bytes_total Number of the bytes received from the pod.
+# TYPE portforwarder_received_bytes_total counter
+portforwarder_received_bytes_total{namespace="shop",pod="api-2",port="8080"} 18
+# HELP portforwarder_sent_bytes_total Number of the bytes sent to the pod.
+# TYPE portforwarder_sent_bytes_total counter
+portforwarder_sent_bytes_total{namespace="shop",pod="api-2",port="8080"} 4
+# HELP portforwarder_stream_errors_total Number of the failed connections, port is 0 when the connection to the pod failed.
+# TYPE portforwarder_stream_errors_total counter
+portforwarder_stream_errors_total{namespace="shop",pod="api-1",port="0"} 1
+# HELP portforwarder_reconnects_total Number of the reconnect attempts after the connection to the pod was lost.
+# TYPE portforwarder_reconnects_total counter
+portforwarder_reconnects_total{namespace="shop",pod="api-1"} 1
+`
+	require.NoError(t, testutil.GatherAndCompare(
+		registry, strings.NewReader(expected),
+		"portforwarder_active_forwards",
+		"portforwarder_connections_accepted_total",
+		"portforwarder_received_bytes_total",
+		"portforwarder_sent_bytes_total",
+		"portforwarder_stream_errors_total",
+		"portforwarder_reconnects_total",
+	))
+
+	count, err := testutil.GatherAndCount(registry, "portforwarder_time_to_ready_seconds")
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestCollector_ForwardRemoved(t *testing.T) {
+	c := New(WithStaleTTL(50 * time.Millisecond))
+
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(c))
+
+	c.ForwardActive("shop", "api-1", 8080, 1)
+	c.ForwardActive("shop", "api-1", 9090, 1)
+	c.ConnectionAccepted("shop", "api-1", 8080)
+	c.Reconnect("shop", "api-1")
+	c.TimeToReady("shop", "api-1", 300*time.Millisecond)
+	c.ForwardActive("shop", "api-2", 8080, 1)
+
+	// another process still forwards to the pod
+	c.ForwardActive("shop", "api-1", 8080, -1)
+	c.ForwardRemoved("shop", "api-1")
+	count, err := testutil.GatherAndCount(registry, "portforwarder_active_forwards")
+	require.NoError(t, err)
+	assert.Equal(t, 3, count)
+
+	c.ForwardActive("shop", "api-1", 9090, -1)
+	c.ForwardRemoved("shop", "api-1")
+
+	expected := `
+# HELP portforwarder_active_forwards Number of the established forwards to the pod port.
+# TYPE portforwarder_active_forwards gauge
+portforwarder_active_forwards{namespace="shop",pod="api-2",port="8080"} 1
+# HELP portforwarder_connections_accepted_total Number of the accepted local connections.
+# TYPE portforwarder_connections_accepted_total counter
+portforwarder_connections_accepted_total{namespace="shop",pod="api-1",port="8080"} 1
+# HELP portforwarder_reconnects_total Number of the reconnect attempts after the connection to the pod was lost.
+# TYPE portforwarder_reconnects_total counter
+portforwarder_reconnects_total{namespace="shop",pod="api-1"} 1
+`
+	require.NoError(t, testutil.GatherAndCompare(
+		registry, strings.NewReader(expected),
+		"portforwarder_active_forwards",
+		"portforwarder_connections_accepted_total",
+		"portforwarder_reconnects_total",
+	))
+
+	time.Sleep(100 * time.Millisecond)
+
+	expected = `
+# HELP portforwarder_active_forwards Number of the established forwards to the pod port.
+# TYPE portforwarder_active_forwards gauge
+portforwarder_active_forwards{namespace="shop",pod="api-2",port="8080"} 1
+`
+	require.NoError(t, testutil.GatherAndCompare(
+		registry, strings.NewReader(expected),
+		"portforwarder_active_forwards",
+		"portforwarder_connections_accepted_total",
+		"portforwarder_reconnects_total",
+		"portforwarder_time_to_ready_seconds",
+	))
+}
+
+func TestCollector_reconnectToAnotherPod(t *testing.T) {
+	c := New()
+
+	registry := prometheus.NewPedanticRegistry()
+	require.NoError(t, registry.Register(c))
+
+	// the order the process reports the reconnect to another pod in
+	c.ForwardActive("shop", "api-1", 8080, 1)
+	c.StreamError("shop", "api-1", 0)
+	c.ForwardActive("shop", "api-1", 8080, -1)
+	c.Reconnect("shop", "api-1")
+	c.ForwardRemoved("shop", "api-1")
+	c.ForwardActive("shop", "api-2", 8080, 1)
+
+	expected := `
+# HELP portforwarder_active_forwards Number of the established forwards to the pod port.
+# TYPE portforwarder_active_forwards gauge
+portforwarder_active_forwards{namespace="shop",pod="api-2",port="8080"} 1
+# HELP portforwarder_stream_errors_total Number of the failed connections, port is 0 when the connection to the pod failed.
+# TYPE portforwarder_stream_errors_total counter
+portforwarder_stream_errors_total{namespace="shop",pod="api-1",port="0"} 1
+# HELP portforwarder_reconnects_total Number of the reconnect attempts after the connection to the pod was lost.
+# TYPE portforwarder_reconnects_total counter
+portforwarder_reconnects_total{namespace="shop",pod="api-1"} 1
+`
+	require.NoError(t, testutil.GatherAndCompare(
+		registry, strings.NewReader(expected),
+		"portforwarder_active_forwards",
+		"portforwarder_stream_errors_total",
+		"portforwarder_reconnects_total",
+	))
+}
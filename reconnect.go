@@ -0,0 +1,55 @@
+package portforwarder
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+const (
+	defaultReconnectInitialBackoff = 500 * time.Millisecond
+	defaultReconnectMaxBackoff     = 30 * time.Second
+)
+
+// ReconnectPolicy makes the process reconnect when the forwarding fails,
+// the target is resolved again on every attempt and the local ports stay the same
+type ReconnectPolicy struct {
+	// MaxAttempts - reconnect attempts in a row, 0 means no limit
+	MaxAttempts int
+	// InitialBackoff - delay before the first attempt, 500ms by default
+	InitialBackoff time.Duration
+	// MaxBackoff - the exponentially growing delay is capped by it, 30s by default
+	MaxBackoff time.Duration
+	// Jitter - fraction of the delay to randomize, from 0 to 1
+	Jitter float64
+}
+
+func (p *ReconnectPolicy) allows(attempt int) bool {
+	if p == nil {
+		return false
+	}
+
+	return p.MaxAttempts == 0 || attempt <= p.MaxAttempts
+}
+
+func (p *ReconnectPolicy) backoff(attempt int) time.Duration {
+	initial, maxBackoff := p.InitialBackoff, p.MaxBackoff
+	if initial <= 0 {
+		initial = defaultReconnectInitialBackoff
+	}
+	if maxBackoff <= 0 {
+		maxBackoff = defaultReconnectMaxBackoff
+	}
+
+	d := time.Duration(math.Min(
+		float64(initial)*math.Pow(2, float64(attempt-1)),
+		float64(maxBackoff),
+	))
+
+	if p.Jitter > 0 {
+		jitter := math.Min(p.Jitter, 1)
+		d = time.Duration(float64(d) * (1 - jitter + 2*jitter*rand.Float64()))
+	}
+
+	return d
+}
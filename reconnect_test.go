@@ -0,0 +1,130 @@
+package portforwarder
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/rest"
+	"testing"
+	"time"
+)
+
+func TestReconnectPolicy(t *testing.T) {
+	t.Run("nil policy does not reconnect", func(t *testing.T) {
+		var p *ReconnectPolicy
+		assert.False(t, p.allows(1))
+	})
+
+	t.Run("max attempts", func(t *testing.T) {
+		p := &ReconnectPolicy{MaxAttempts: 2}
+		assert.True(t, p.allows(1))
+		assert.True(t, p.allows(2))
+		assert.False(t, p.allows(3))
+		assert.True(t, (&ReconnectPolicy{}).allows(100))
+	})
+
+	t.Run("exponential backoff is capped", func(t *testing.T) {
+		p := &ReconnectPolicy{InitialBackoff: time.Second, MaxBackoff: 5 * time.Second}
+		assert.Equal(t, time.Second, p.backoff(1))
+		assert.Equal(t, 2*time.Second, p.backoff(2))
+		assert.Equal(t, 4*time.Second, p.backoff(3))
+		assert.Equal(t, 5*time.Second, p.backoff(4))
+	})
+
+	t.Run("jitter", func(t *testing.T) {
+		p := &ReconnectPolicy{InitialBackoff: time.Second, Jitter: 0.5}
+		for i := 0; i < 10; i++ {
+			d := p.backoff(1)
+			assert.GreaterOrEqual(t, d, 500*time.Millisecond)
+			assert.LessOrEqual(t, d, 1500*time.Millisecond)
+		}
+	})
+}
+
+func TestPortForwarder_PortForwardAPod_reconnect(t *testing.T) {
+	ctx := context.TODO()
+	ls := map[string]string{"app": "api"}
+
+	pl := newMockPodProvider(t)
+	pl.EXPECT().listPods(ctx, mock.Anything).Times(1).
+		Return(&v1.PodList{Items: []v1.Pod{readyPod("api-1")}}, nil)
+	pl.EXPECT().listPods(ctx, mock.Anything).Times(1).
+		Return(&v1.PodList{Items: []v1.Pod{readyPod("api-2")}}, nil)
+
+	lp := newMockListenerProvider(t)
+	lp.EXPECT().listen([]string{"localhost"}, uint(0)).Times(1).Return(newTestListeners(3999), nil)
+
+	f := newMockPortForwarder(t)
+	f.EXPECT().
+		forward(mock.Anything, matchForwardedPorts("3999:8080"), mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		RunAndReturn(func(_ httpstream.Dialer, _ []*forwardedPort, _ <-chan *acceptedConn, _ connHooks, _ <-chan struct{}, readyCh chan struct{}) error {
+			close(readyCh)
+			return ErrLostConnection
+		}).
+		Times(1)
+	f.EXPECT().
+		forward(mock.Anything, matchForwardedPorts("3999:8080"), mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		RunAndReturn(forwardUntilStopped).
+		Times(1)
+
+	pf := withCoreClient(t, &PortForwarder{
+		listenerProvider: lp,
+		podProvider:      pl,
+		forwarder:        f,
+		restCfg:          &rest.Config{},
+	})
+
+	process, err := pf.PortForwardAPod(ctx, &TargetPod{
+		Port:          8080,
+		LabelSelector: ls,
+		Reconnect:     &ReconnectPolicy{MaxAttempts: 3, InitialBackoff: 10 * time.Millisecond},
+	})
+	require.NoError(t, err)
+
+	go func() {
+		<-time.After(500 * time.Millisecond)
+		process.Stop()
+	}()
+
+	<-process.Started()
+	<-process.Finished()
+	assert.NoError(t, process.Err())
+	assert.Equal(t, uint(3999), process.Port)
+}
+
+func TestPortForwarder_PortForwardAPod_reconnectGivesUp(t *testing.T) {
+	ctx := context.TODO()
+	pod := readyPod("api-1")
+
+	pl := newMockPodProvider(t)
+	pl.EXPECT().getPod(ctx, "default", "api-1").Times(3).Return(&pod, nil)
+
+	lp := newMockListenerProvider(t)
+	lp.EXPECT().listen([]string{"localhost"}, uint(0)).Times(1).Return(newTestListeners(3999), nil)
+
+	f := newMockPortForwarder(t)
+	f.EXPECT().
+		forward(mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Times(3).
+		Return(ErrLostConnection)
+
+	pf := withCoreClient(t, &PortForwarder{
+		listenerProvider: lp,
+		podProvider:      pl,
+		forwarder:        f,
+		restCfg:          &rest.Config{},
+	})
+
+	process, err := pf.PortForwardAPod(ctx, &TargetPod{
+		Port:      8080,
+		Name:      "api-1",
+		Reconnect: &ReconnectPolicy{MaxAttempts: 2, InitialBackoff: time.Millisecond},
+	})
+	require.NoError(t, err)
+
+	<-process.Finished()
+	require.ErrorIs(t, process.Err(), ErrLostConnection)
+}
@@ -0,0 +1,176 @@
+package portforwarder
+
+import (
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+type TargetService struct {
+	// Port of the service in k8s, it gets translated to the target port of the backing pod
+	Port uint
+	// PortName - optional name of the service port, can be used instead of Port
+	PortName string
+	// Name of the service
+	Name string
+	// Namespace of the service
+	Namespace string
+	// PodSelectionStrategy - optional strategy to pick one of the ready backing pods
+	PodSelectionStrategy PodSelectionStrategy
+	// LocalPort - optional local port to forward to, a free local port is picked when not specified
+	LocalPort uint
+	// LocalAddresses - optional local addresses to listen on, e.g. 0.0.0.0, localhost by default
+	LocalAddresses []string
+	// Reconnect - optional policy to reconnect to another backing pod when the connection drops
+	Reconnect *ReconnectPolicy
+	// OnEvent - optional handler of the lifecycle events
+	OnEvent EventHandler
+}
+
+func (s *TargetService) applyDefaults(namespace string) {
+	if s.Namespace == "" {
+		s.Namespace = namespace
+	}
+
+	if len(s.LocalAddresses) == 0 {
+		s.LocalAddresses = defaultLocalAddresses()
+	}
+}
+
+func (s *TargetService) validate() error {
+	if s.Port == 0 && s.PortName == "" {
+		return fmt.Errorf("%w service port or port name is required", ErrTargetServiceValidation)
+	}
+
+	if s.Namespace == "" {
+		return fmt.Errorf("%w namespace cannot be empty", ErrTargetServiceValidation)
+	}
+
+	if s.Name == "" {
+		return fmt.Errorf("%w service name should be specified", ErrTargetServiceValidation)
+	}
+
+	return nil
+}
+
+// PortForwardAService resolves the selector and the target port of the service
+// to a backing pod and forwards a free local port to it, like kubectl port-forward svc/name does
+func (pf *PortForwarder) PortForwardAService(
+	ctx context.Context,
+	target *TargetService,
+) (*PortForwardProcess, error) {
+	target.applyDefaults(pf.namespace())
+	if err := target.validate(); err != nil {
+		return nil, err
+	}
+
+	// the time to ready is measured from here, so it includes resolving the service and the pod
+	metrics := pf.newForwardMetrics(target.Namespace)
+
+	svc, err := pf.serviceProvider.getService(ctx, target.Namespace, target.Name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrServiceNotFound, err.Error())
+	}
+
+	svcPort, err := findServicePort(svc, target.Port, target.PortName)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(svc.Spec.Selector) == 0 {
+		return nil, fmt.Errorf(
+			"%w: service %s in namespace %s has no selector",
+			ErrPodNotFound, svc.Name, target.Namespace,
+		)
+	}
+
+	podTarget := &TargetPod{
+		Namespace:            target.Namespace,
+		LabelSelector:        svc.Spec.Selector,
+		PodSelectionStrategy: target.PodSelectionStrategy,
+	}
+
+	onEvent := metrics.withMetrics(pf.withLogging(target.OnEvent, target.Namespace))
+	onEvent.emit(Resolving{Namespace: target.Namespace})
+	pod, err := pf.resolveTargetPod(ctx, podTarget)
+	if err != nil {
+		return nil, fmt.Errorf("could not port forward a service: %w", err)
+	}
+	onEvent.emit(PodSelected{Namespace: target.Namespace, Name: pod.GetName()})
+
+	podPort, err := resolveTargetPort(pod, svcPort)
+	if err != nil {
+		return nil, err
+	}
+
+	return pf.forwardToPod(ctx, &forwardCommand{
+		namespace:      target.Namespace,
+		podName:        pod.GetName(),
+		targetPorts:    []uint{podPort},
+		localPort:      target.LocalPort,
+		localAddresses: target.LocalAddresses,
+		reconnect:      target.Reconnect,
+		resolvePod: func(ctx context.Context) (string, []uint, error) {
+			pod, err := pf.resolveTargetPod(ctx, podTarget)
+			if err != nil {
+				return "", nil, err
+			}
+
+			podPort, err := resolveTargetPort(pod, svcPort)
+			return pod.GetName(), []uint{podPort}, err
+		},
+		onEvent: onEvent,
+		metrics: metrics,
+	})
+}
+
+func findServicePort(svc *corev1.Service, port uint, portName string) (*corev1.ServicePort, error) {
+	for i := range svc.Spec.Ports {
+		p := &svc.Spec.Ports[i]
+		if portName != "" && p.Name == portName {
+			return p, nil
+		}
+
+		if port != 0 && uint(p.Port) == port {
+			return p, nil
+		}
+	}
+
+	if portName != "" {
+		return nil, fmt.Errorf(
+			"%w: service %s does not have a port named %s",
+			ErrServicePortNotFound, svc.Name, portName,
+		)
+	}
+
+	return nil, fmt.Errorf(
+		"%w: service %s does not have a service port %d",
+		ErrServicePortNotFound, svc.Name, port,
+	)
+}
+
+// resolveTargetPort translates the service port to the container port of the pod,
+// the named target ports are looked up in the pod containers
+func resolveTargetPort(pod *corev1.Pod, svcPort *corev1.ServicePort) (uint, error) {
+	switch svcPort.TargetPort.Type {
+	case intstr.Int:
+		if svcPort.TargetPort.IntVal > 0 {
+			return uint(svcPort.TargetPort.IntVal), nil
+		}
+		return uint(svcPort.Port), nil
+	case intstr.String:
+		if svcPort.TargetPort.StrVal == "" {
+			return uint(svcPort.Port), nil
+		}
+
+		if containerPort, ok := findContainerPort(pod, svcPort.TargetPort.StrVal); ok {
+			return containerPort, nil
+		}
+	}
+
+	return 0, fmt.Errorf(
+		"%w: pod %s does not have a container port named %s",
+		ErrServicePortNotFound, pod.GetName(), svcPort.TargetPort.StrVal,
+	)
+}
@@ -0,0 +1,208 @@
+package portforwarder
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/rest"
+	"testing"
+	"time"
+)
+
+func TestPortForwarder_PortForwardAService(t *testing.T) {
+	t.Run("forward a service with named target port", func(t *testing.T) {
+		ctx := context.TODO()
+		namespace := "kafka-ns"
+		selector := map[string]string{"app": "kafka"}
+
+		svc := &v1.Service{}
+		svc.Name = "kafka"
+		svc.Spec.Selector = selector
+		svc.Spec.Ports = []v1.ServicePort{
+			{Name: "client", Port: 9092, TargetPort: intstr.FromString("broker")},
+		}
+
+		pod := readyPod("kafka-pod-0")
+		pod.Spec.Containers = []v1.Container{
+			{Ports: []v1.ContainerPort{{Name: "broker", ContainerPort: 29092}}},
+		}
+
+		sp := newMockServiceProvider(t)
+		sp.EXPECT().getService(ctx, namespace, "kafka").Times(1).Return(svc, nil)
+
+		pl := newMockPodProvider(t)
+		pl.EXPECT().
+			listPods(ctx, &listPodsCommand{
+				namespace:      namespace,
+				labelSelectors: selector,
+			}).
+			Times(1).
+			Return(&v1.PodList{Items: []v1.Pod{pod}}, nil)
+
+		lp := newMockListenerProvider(t)
+		lp.EXPECT().listen([]string{"localhost"}, uint(0)).Times(1).Return(newTestListeners(3999), nil)
+
+		f := newMockPortForwarder(t)
+		f.EXPECT().
+			forward(mock.Anything, matchForwardedPorts("3999:29092"), mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Times(1).
+			Return(nil)
+
+		pf := withCoreClient(t, &PortForwarder{
+			listenerProvider: lp,
+			podProvider:      pl,
+			serviceProvider:  sp,
+			forwarder:        f,
+			restCfg:          &rest.Config{},
+		})
+
+		process, err := pf.PortForwardAService(ctx, &TargetService{
+			Port:      9092,
+			Name:      "kafka",
+			Namespace: namespace,
+		})
+		require.NoError(t, err)
+
+		go func() {
+			<-time.After(500 * time.Millisecond)
+			process.Stop()
+		}()
+
+		<-process.Finished()
+		assert.NoError(t, process.Err())
+	})
+
+	t.Run("named target port is resolved again on reconnect", func(t *testing.T) {
+		ctx := context.TODO()
+		selector := map[string]string{"app": "kafka"}
+
+		svc := &v1.Service{}
+		svc.Name = "kafka"
+		svc.Spec.Selector = selector
+		svc.Spec.Ports = []v1.ServicePort{
+			{Name: "client", Port: 9092, TargetPort: intstr.FromString("broker")},
+		}
+
+		brokerPod := func(name string, port int32) v1.Pod {
+			pod := readyPod(name)
+			pod.Spec.Containers = []v1.Container{
+				{Ports: []v1.ContainerPort{{Name: "broker", ContainerPort: port}}},
+			}
+			return pod
+		}
+
+		sp := newMockServiceProvider(t)
+		sp.EXPECT().getService(ctx, "default", "kafka").Times(1).Return(svc, nil)
+
+		pl := newMockPodProvider(t)
+		pl.EXPECT().listPods(mock.Anything, mock.Anything).Times(1).
+			Return(&v1.PodList{Items: []v1.Pod{brokerPod("kafka-pod-0", 29092)}}, nil)
+		pl.EXPECT().listPods(mock.Anything, mock.Anything).Times(1).
+			Return(&v1.PodList{Items: []v1.Pod{brokerPod("kafka-pod-1", 39092)}}, nil)
+
+		lp := newMockListenerProvider(t)
+		lp.EXPECT().listen([]string{"localhost"}, uint(0)).Times(1).Return(newTestListeners(3999), nil)
+
+		f := newMockPortForwarder(t)
+		f.EXPECT().
+			forward(mock.Anything, matchForwardedPorts("3999:29092"), mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Times(1).
+			Return(ErrLostConnection)
+		f.EXPECT().
+			forward(mock.Anything, matchForwardedPorts("3999:39092"), mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			RunAndReturn(forwardUntilStopped).
+			Times(1)
+
+		pf := withCoreClient(t, &PortForwarder{
+			listenerProvider: lp,
+			podProvider:      pl,
+			serviceProvider:  sp,
+			forwarder:        f,
+			restCfg:          &rest.Config{},
+		})
+
+		process, err := pf.PortForwardAService(ctx, &TargetService{
+			Port:      9092,
+			Name:      "kafka",
+			Reconnect: &ReconnectPolicy{MaxAttempts: 3, InitialBackoff: time.Millisecond},
+		})
+		require.NoError(t, err)
+
+		select {
+		case <-process.Started():
+		case <-time.After(5 * time.Second):
+			t.Fatal("process did not start")
+		}
+
+		assert.Equal(t, map[uint]uint{39092: 3999}, process.Ports())
+		process.Stop()
+		assert.NoError(t, process.Err())
+	})
+
+	t.Run("unknown service port", func(t *testing.T) {
+		ctx := context.TODO()
+		svc := &v1.Service{}
+		svc.Name = "nginx"
+		svc.Spec.Selector = map[string]string{"run": "nginx"}
+		svc.Spec.Ports = []v1.ServicePort{{Port: 80}}
+
+		sp := newMockServiceProvider(t)
+		sp.EXPECT().getService(ctx, "default", "nginx").Times(1).Return(svc, nil)
+
+		pf := &PortForwarder{serviceProvider: sp}
+
+		process, err := pf.PortForwardAService(ctx, &TargetService{Port: 8080, Name: "nginx"})
+		require.ErrorIs(t, err, ErrServicePortNotFound)
+		assert.Nil(t, process)
+	})
+}
+
+func Test_resolveTargetPort(t *testing.T) {
+	pod := &v1.Pod{}
+	pod.Name = "nginx"
+	pod.Spec.Containers = []v1.Container{
+		{Ports: []v1.ContainerPort{{Name: "http", ContainerPort: 8080}}},
+	}
+
+	tests := []struct {
+		name    string
+		svcPort v1.ServicePort
+		want    uint
+		wantErr error
+	}{
+		{
+			name:    "numeric target port",
+			svcPort: v1.ServicePort{Port: 80, TargetPort: intstr.FromInt(8081)},
+			want:    8081,
+		},
+		{
+			name:    "empty target port defaults to service port",
+			svcPort: v1.ServicePort{Port: 80},
+			want:    80,
+		},
+		{
+			name:    "named target port",
+			svcPort: v1.ServicePort{Port: 80, TargetPort: intstr.FromString("http")},
+			want:    8080,
+		},
+		{
+			name:    "unknown named target port",
+			svcPort: v1.ServicePort{Port: 80, TargetPort: intstr.FromString("metrics")},
+			wantErr: ErrServicePortNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveTargetPort(pod, &tt.svcPort)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package portforwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Forward is a named forward of the session, exactly one of the targets should be set
+type Forward struct {
+	// Name of the forward, unique in the session
+	Name     string
+	Pod      *TargetPod
+	Service  *TargetService
+	Workload *TargetWorkload
+}
+
+func (f Forward) validate() error {
+	if f.Name == "" {
+		return fmt.Errorf("%w forward name should be specified", ErrSessionValidation)
+	}
+
+	targets := 0
+	for _, set := range []bool{f.Pod != nil, f.Service != nil, f.Workload != nil} {
+		if set {
+			targets++
+		}
+	}
+
+	if targets != 1 {
+		return fmt.Errorf("%w forward %s should have exactly one target", ErrSessionValidation, f.Name)
+	}
+
+	return nil
+}
+
+func (pf *PortForwarder) start(ctx context.Context, f Forward) (*PortForwardProcess, error) {
+	switch {
+	case f.Pod != nil:
+		return pf.PortForwardAPod(ctx, f.Pod)
+	case f.Service != nil:
+		return pf.PortForwardAService(ctx, f.Service)
+	default:
+		return pf.PortForwardAWorkload(ctx, f.Workload)
+	}
+}
+
+// Session is a named set of forwards started together, e.g. a whole stack of services for integration tests
+type Session struct {
+	names      []string
+	processes  map[string]*PortForwardProcess
+	readyCh    chan struct{}
+	finishedCh chan struct{}
+}
+
+// StartSession starts all the forwards, when any of them cannot be started
+// the already started ones are stopped and the error is returned
+func (pf *PortForwarder) StartSession(ctx context.Context, forwards ...Forward) (*Session, error) {
+	names := make([]string, 0, len(forwards))
+	for _, f := range forwards {
+		if err := f.validate(); err != nil {
+			return nil, err
+		}
+
+		for _, name := range names {
+			if name == f.Name {
+				return nil, fmt.Errorf("%w duplicate forward name %s", ErrSessionValidation, f.Name)
+			}
+		}
+		names = append(names, f.Name)
+	}
+
+	s := &Session{
+		names:      names,
+		processes:  make(map[string]*PortForwardProcess, len(forwards)),
+		readyCh:    make(chan struct{}),
+		finishedCh: make(chan struct{}),
+	}
+
+	for _, f := range forwards {
+		process, err := pf.start(ctx, f)
+		if err != nil {
+			s.StopAll()
+			// the started forwards are not returned, so they are finished before returning
+			for _, p := range s.processes {
+				<-p.Finished()
+			}
+			return nil, fmt.Errorf("could not start forward %s: %w", f.Name, err)
+		}
+		s.processes[f.Name] = process
+	}
+
+	go s.waitForReady()
+	go s.waitForFinished()
+
+	return s, nil
+}
+
+// waitForReady stops the session when any of the forwards finishes before it is started,
+// so the session is either ready or finished with the error
+func (s *Session) waitForReady() {
+	for _, p := range s.processes {
+		select {
+		case <-p.Started():
+		case <-p.Finished():
+			if !isClosed(p.Started()) {
+				s.StopAll()
+				return
+			}
+		}
+	}
+	close(s.readyCh)
+}
+
+func (s *Session) waitForFinished() {
+	for _, p := range s.processes {
+		<-p.Finished()
+	}
+	close(s.finishedCh)
+}
+
+// List returns the names of the forwards in the order they were started
+func (s *Session) List() []string {
+	return append([]string(nil), s.names...)
+}
+
+// Get returns the process of the named forward
+func (s *Session) Get(name string) (*PortForwardProcess, bool) {
+	p, ok := s.processes[name]
+	return p, ok
+}
+
+// Ready is closed when every forward of the session is started,
+// the session is stopped instead when any of the forwards finishes before it is started
+func (s *Session) Ready() <-chan struct{} {
+	return s.readyCh
+}
+
+// Finished is closed when every forward of the session is finished
+func (s *Session) Finished() <-chan struct{} {
+	return s.finishedCh
+}
+
+// StopAll stops all the forwards and waits for the forwarding to end, like PortForwardProcess.Stop does,
+// so it is safe to call from the event handler
+func (s *Session) StopAll() {
+	var wg sync.WaitGroup
+	for _, p := range s.processes {
+		wg.Add(1)
+		go func(p *PortForwardProcess) {
+			defer wg.Done()
+			p.Stop()
+		}(p)
+	}
+	wg.Wait()
+}
+
+// Shutdown drains all the forwards until the context is done, like PortForwardProcess.Shutdown does,
+// it returns the number of the connections cut in all of them
+func (s *Session) Shutdown(ctx context.Context) (int, error) {
+	var wg sync.WaitGroup
+	cuts := make([]int, len(s.names))
+	for i, name := range s.names {
+		wg.Add(1)
+		go func(i int, p *PortForwardProcess) {
+			defer wg.Done()
+			cuts[i], _ = p.Shutdown(ctx)
+		}(i, s.processes[name])
+	}
+	wg.Wait()
+
+	cut := 0
+	for _, n := range cuts {
+		cut += n
+	}
+
+	if cut > 0 {
+		return cut, ctx.Err()
+	}
+	return 0, nil
+}
+
+// Err joins the errors of the forwards, prefixed with their names
+func (s *Session) Err() error {
+	var errs []error
+	for _, name := range s.names {
+		if err := s.processes[name].Err(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,244 @@
+package portforwarder
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/client-go/rest"
+	"testing"
+	"time"
+)
+
+func newSessionForwarder(t *testing.T, podNames ...string) *PortForwarder {
+	t.Helper()
+
+	pl := newMockPodProvider(t)
+	for _, name := range podNames {
+		pod := readyPod(name)
+		pl.EXPECT().getPod(context.TODO(), "default", name).Times(1).Return(&pod, nil)
+	}
+
+	return withCoreClient(t, &PortForwarder{
+		listenerProvider: newNetListenerProvider("tcp"),
+		podProvider:      pl,
+		restCfg:          &rest.Config{},
+	})
+}
+
+func forwardUntilStopped(_ httpstream.Dialer, _ []*forwardedPort, _ <-chan *acceptedConn, _ connHooks, stopCh <-chan struct{}, readyCh chan struct{}) error {
+	close(readyCh)
+	<-stopCh
+	return nil
+}
+
+func TestPortForwarder_StartSession(t *testing.T) {
+	pf := newSessionForwarder(t, "api-1", "db-0")
+
+	f := newMockPortForwarder(t)
+	f.EXPECT().
+		forward(mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		RunAndReturn(forwardUntilStopped).
+		Times(2)
+	pf.forwarder = f
+
+	s, err := pf.StartSession(
+		context.TODO(),
+		Forward{Name: "api", Pod: &TargetPod{Name: "api-1", Port: 8080}},
+		Forward{Name: "db", Pod: &TargetPod{Name: "db-0", Port: 5432}},
+	)
+	require.NoError(t, err)
+
+	select {
+	case <-s.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("session is not ready")
+	}
+
+	assert.Equal(t, []string{"api", "db"}, s.List())
+
+	api, ok := s.Get("api")
+	require.True(t, ok)
+	assert.NotZero(t, api.Port)
+
+	_, ok = s.Get("cache")
+	assert.False(t, ok)
+
+	s.StopAll()
+	<-s.Finished()
+	assert.NoError(t, s.Err())
+}
+
+func TestSession_StopAll_fromEventHandler(t *testing.T) {
+	pf := newSessionForwarder(t, "api-1", "db-0")
+
+	f := newMockPortForwarder(t)
+	f.EXPECT().
+		forward(mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		RunAndReturn(forwardUntilStopped).
+		Times(2)
+	pf.forwarder = f
+
+	sessionCh := make(chan *Session, 1)
+	s, err := pf.StartSession(
+		context.TODO(),
+		Forward{Name: "api", Pod: &TargetPod{Name: "api-1", Port: 8080, OnEvent: func(e Event) {
+			if _, ok := e.(Stopped); ok {
+				(<-sessionCh).StopAll()
+			}
+		}}},
+		Forward{Name: "db", Pod: &TargetPod{Name: "db-0", Port: 5432}},
+	)
+	require.NoError(t, err)
+	sessionCh <- s
+	<-s.Ready()
+
+	api, _ := s.Get("api")
+	api.Stop()
+
+	select {
+	case <-s.Finished():
+	case <-time.After(5 * time.Second):
+		t.Fatal("session stopped from the event handler did not finish")
+	}
+}
+
+func TestPortForwarder_StartSession_forwardFailed(t *testing.T) {
+	pf := newSessionForwarder(t, "api-1", "db-0")
+
+	f := newMockPortForwarder(t)
+	f.EXPECT().
+		forward(mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		RunAndReturn(func(_ httpstream.Dialer, ports []*forwardedPort, _ <-chan *acceptedConn, _ connHooks, stopCh <-chan struct{}, readyCh chan struct{}) error {
+			if ports[0].remote == 5432 {
+				return errors.New("connection refused")
+			}
+			close(readyCh)
+			<-stopCh
+			return nil
+		}).
+		Times(2)
+	pf.forwarder = f
+
+	s, err := pf.StartSession(
+		context.TODO(),
+		Forward{Name: "api", Pod: &TargetPod{Name: "api-1", Port: 8080}},
+		Forward{Name: "db", Pod: &TargetPod{Name: "db-0", Port: 5432}},
+	)
+	require.NoError(t, err)
+
+	select {
+	case <-s.Finished():
+	case <-time.After(5 * time.Second):
+		t.Fatal("session is not finished")
+	}
+
+	assert.False(t, isClosed(s.Ready()))
+	require.Error(t, s.Err())
+	assert.Contains(t, s.Err().Error(), "db: ")
+	assert.Contains(t, s.Err().Error(), "connection refused")
+}
+
+func TestPortForwarder_StartSession_notStarted(t *testing.T) {
+	pod := readyPod("api-1")
+	pl := newMockPodProvider(t)
+	pl.EXPECT().getPod(context.TODO(), "default", "api-1").Times(1).Return(&pod, nil)
+	pl.EXPECT().getPod(context.TODO(), "default", "db-0").Times(1).Return(nil, errors.New("pods \"db-0\" not found"))
+
+	f := newMockPortForwarder(t)
+	f.EXPECT().
+		forward(mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		RunAndReturn(forwardUntilStopped).
+		Times(1)
+
+	pf := withCoreClient(t, &PortForwarder{
+		listenerProvider: newNetListenerProvider("tcp"),
+		podProvider:      pl,
+		forwarder:        f,
+		restCfg:          &rest.Config{},
+	})
+
+	recorder := &eventRecorder{}
+	_, err := pf.StartSession(
+		context.TODO(),
+		Forward{Name: "api", Pod: &TargetPod{Name: "api-1", Port: 8080, OnEvent: recorder.handle}},
+		Forward{Name: "db", Pod: &TargetPod{Name: "db-0", Port: 5432}},
+	)
+	require.ErrorIs(t, err, ErrPodNotFound)
+	assert.Contains(t, err.Error(), "could not start forward db")
+
+	events := recorder.recorded()
+	assert.IsType(t, Stopped{}, events[len(events)-1])
+}
+
+func TestPortForwarder_StartSession_validation(t *testing.T) {
+	pf := &PortForwarder{restCfg: &rest.Config{}}
+
+	tt := []struct {
+		name     string
+		forwards []Forward
+	}{
+		{
+			name:     "no name",
+			forwards: []Forward{{Pod: &TargetPod{Name: "api-1", Port: 8080}}},
+		},
+		{
+			name:     "no target",
+			forwards: []Forward{{Name: "api"}},
+		},
+		{
+			name: "several targets",
+			forwards: []Forward{{
+				Name:    "api",
+				Pod:     &TargetPod{Name: "api-1", Port: 8080},
+				Service: &TargetService{Name: "api", Port: 80},
+			}},
+		},
+		{
+			name: "duplicate name",
+			forwards: []Forward{
+				{Name: "api", Pod: &TargetPod{Name: "api-1", Port: 8080}},
+				{Name: "api", Pod: &TargetPod{Name: "api-2", Port: 8080}},
+			},
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := pf.StartSession(context.TODO(), tc.forwards...)
+			require.ErrorIs(t, err, ErrSessionValidation)
+		})
+	}
+}
+
+func TestSession_Shutdown(t *testing.T) {
+	pf := newSessionForwarder(t, "api-1", "db-0")
+
+	f := newMockPortForwarder(t)
+	f.EXPECT().
+		forward(mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		RunAndReturn(forwardUntilStopped).
+		Times(2)
+	pf.forwarder = f
+
+	s, err := pf.StartSession(
+		context.TODO(),
+		Forward{Name: "api", Pod: &TargetPod{Name: "api-1", Port: 8080}},
+		Forward{Name: "db", Pod: &TargetPod{Name: "db-0", Port: 5432}},
+	)
+	require.NoError(t, err)
+	<-s.Ready()
+
+	cut, err := s.Shutdown(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, cut)
+
+	select {
+	case <-s.Finished():
+	case <-time.After(5 * time.Second):
+		t.Fatal("session is not finished")
+	}
+	assert.NoError(t, s.Err())
+}
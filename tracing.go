@@ -0,0 +1,96 @@
+package portforwarder
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+)
+
+const tracerName = "github.com/denismitr/portforwarder"
+
+const (
+	namespaceKey  = attribute.Key("k8s.namespace.name")
+	podKey        = attribute.Key("k8s.pod.name")
+	transportKey  = attribute.Key("portforwarder.transport")
+	localPortKey  = attribute.Key("portforwarder.local_port")
+	remotePortKey = attribute.Key("portforwarder.remote_port")
+)
+
+// tracer creates the spans of the phases, they are dropped without the tracer provider
+func (pf *PortForwarder) tracer() trace.Tracer {
+	return newTracer(pf.tracerProvider)
+}
+
+func newTracer(tp trace.TracerProvider) trace.Tracer {
+	if tp == nil {
+		return noopTracer{}
+	}
+	return tp.Tracer(tracerName)
+}
+
+// noopTracer does not touch the context unlike the tracer of trace.NewNoopTracerProvider,
+// so the providers are called with the very context of the caller when nothing is traced
+type noopTracer struct{}
+
+func (noopTracer) Start(ctx context.Context, _ string, _ ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return ctx, trace.SpanFromContext(context.Background())
+}
+
+// endSpan records the error the traced phase failed with and ends the span
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+func (pf *PortForwarder) startResolveSpan(ctx context.Context, namespace string) (context.Context, trace.Span) {
+	return pf.tracer().Start(ctx, "portforwarder.resolve", trace.WithAttributes(namespaceKey.String(namespace)))
+}
+
+func endResolveSpan(span trace.Span, podName string, err error) {
+	if err == nil {
+		span.SetAttributes(podKey.String(podName))
+	}
+	endSpan(span, err)
+}
+
+// resolveTargetPod resolves the pod of the target in the span
+func (pf *PortForwarder) resolveTargetPod(ctx context.Context, target *TargetPod) (*corev1.Pod, error) {
+	ctx, span := pf.startResolveSpan(ctx, target.Namespace)
+	pod, err := resolvePod(ctx, pf.podProvider, target)
+	if err != nil {
+		endResolveSpan(span, "", err)
+		return nil, err
+	}
+
+	endResolveSpan(span, pod.GetName(), nil)
+	return pod, nil
+}
+
+// resolvePodName resolves the name of the pod of the target in the span
+func (pf *PortForwarder) resolvePodName(ctx context.Context, target *TargetPod) (string, error) {
+	ctx, span := pf.startResolveSpan(ctx, target.Namespace)
+	podName, err := getPodName(ctx, pf.podProvider, target)
+	endResolveSpan(span, podName, err)
+	return podName, err
+}
+
+// tracedDialer traces the upgrades of the streaming connections to the API server
+type tracedDialer struct {
+	httpstream.Dialer
+	ctx    context.Context
+	tracer trace.Tracer
+	attrs  []attribute.KeyValue
+}
+
+func (d *tracedDialer) Dial(protocols ...string) (httpstream.Connection, string, error) {
+	_, span := d.tracer.Start(d.ctx, "portforwarder.dial", trace.WithAttributes(d.attrs...))
+	conn, protocol, err := d.Dialer.Dial(protocols...)
+	endSpan(span, err)
+	return conn, protocol, err
+}
@@ -0,0 +1,141 @@
+package portforwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"io"
+	"k8s.io/client-go/rest"
+	"net"
+	"testing"
+	"time"
+)
+
+func newTracedForwarder(t *testing.T, pl podProvider, restCfg *rest.Config) (*PortForwarder, *tracetest.SpanRecorder, *sdktrace.TracerProvider) {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	t.Cleanup(func() {
+		_ = tp.Shutdown(context.Background())
+	})
+
+	pf := withCoreClient(t, &PortForwarder{
+		listenerProvider: newNetListenerProvider("tcp"),
+		podProvider:      pl,
+		forwarder:        &streamForwarder{},
+		restCfg:          restCfg,
+		transport:        TransportWebSocket,
+	})
+	WithTracerProvider(tp)(pf)
+
+	return pf, recorder, tp
+}
+
+func spansByName(spans []sdktrace.ReadOnlySpan) map[string][]sdktrace.ReadOnlySpan {
+	byName := make(map[string][]sdktrace.ReadOnlySpan)
+	for _, s := range spans {
+		byName[s.Name()] = append(byName[s.Name()], s)
+	}
+	return byName
+}
+
+func spanAttr(s sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range s.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestPortForwarder_PortForwardAPod_tracing(t *testing.T) {
+	srv := newWebsocketPodServer(t)
+
+	pod := readyPod("api-1")
+	pl := newMockPodProvider(t)
+	pl.EXPECT().getPod(mock.Anything, "default", "api-1").Times(1).Return(&pod, nil)
+
+	pf, recorder, tp := newTracedForwarder(t, pl, &rest.Config{Host: srv.URL, BearerToken: "token"})
+
+	ctx, root := tp.Tracer("test").Start(context.Background(), "integration test")
+	process, err := pf.PortForwardAPod(ctx, &TargetPod{
+		Name:           "api-1",
+		Port:           8080,
+		LocalAddresses: []string{"127.0.0.1"},
+		Readiness:      &ReadinessProbe{Period: 10 * time.Millisecond},
+	})
+	require.NoError(t, err)
+
+	select {
+	case <-process.Started():
+	case <-time.After(5 * time.Second):
+		t.Fatal("process did not start")
+	}
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", process.Port))
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "8080:ping", string(resp))
+	require.NoError(t, conn.Close())
+
+	process.Stop()
+	root.End()
+
+	spans := spansByName(recorder.Ended())
+	for _, name := range []string{"portforwarder.resolve", "portforwarder.dial", "portforwarder.readiness"} {
+		require.Len(t, spans[name], 1, name)
+	}
+	// the connection of the probe, reset by it, and the one above
+	require.Len(t, spans["portforwarder.connection"], 2)
+	connection := spans["portforwarder.connection"][1]
+
+	for _, s := range recorder.Ended() {
+		if s.Name() == "integration test" {
+			continue
+		}
+		assert.Equal(t, root.SpanContext().TraceID(), s.Parent().TraceID(), s.Name())
+		assert.Equal(t, root.SpanContext().SpanID(), s.Parent().SpanID(), s.Name())
+		if s != spans["portforwarder.connection"][0] {
+			assert.Equal(t, codes.Unset, s.Status().Code, s.Name())
+		}
+	}
+
+	resolve := spans["portforwarder.resolve"][0]
+	assert.Equal(t, "default", spanAttr(resolve, namespaceKey).AsString())
+	assert.Equal(t, "api-1", spanAttr(resolve, podKey).AsString())
+
+	dial := spans["portforwarder.dial"][0]
+	assert.Equal(t, "api-1", spanAttr(dial, podKey).AsString())
+	assert.Equal(t, TransportWebSocket.String(), spanAttr(dial, transportKey).AsString())
+
+	assert.Equal(t, int64(8080), spanAttr(connection, remotePortKey).AsInt64())
+	assert.Equal(t, int64(process.Port), spanAttr(connection, localPortKey).AsInt64())
+}
+
+func TestPortForwarder_PortForwardAPod_tracingErrors(t *testing.T) {
+	pl := newMockPodProvider(t)
+	pl.EXPECT().getPod(mock.Anything, "default", "api-1").Times(1).Return(nil, errors.New("pods \"api-1\" not found"))
+
+	pf, recorder, _ := newTracedForwarder(t, pl, &rest.Config{})
+
+	_, err := pf.PortForwardAPod(context.Background(), &TargetPod{Name: "api-1", Port: 8080})
+	require.ErrorIs(t, err, ErrPodNotFound)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	assert.Equal(t, "portforwarder.resolve", spans[0].Name())
+	assert.Equal(t, codes.Error, spans[0].Status().Code)
+	assert.Contains(t, spans[0].Status().Description, "not found")
+}
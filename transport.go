@@ -0,0 +1,318 @@
+package portforwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"io"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	spdystream "k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/transport/spdy"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Transport is the protocol of the streaming connection to the API server
+type Transport int
+
+const (
+	// TransportSPDY upgrades the connection to SPDY, like kubectl always did
+	TransportSPDY Transport = iota
+	// TransportWebSocket tunnels SPDY over a WebSocket (portforward.k8s.io v2),
+	// which passes the proxies and load balancers that drop SPDY upgrades
+	TransportWebSocket
+	// TransportWebSocketWithFallback tries the WebSocket first
+	// and falls back to SPDY when the server rejects the WebSocket handshake
+	TransportWebSocketWithFallback
+)
+
+const (
+	// websocketTunnelingPrefix is prepended to the streaming protocols
+	// to ask the API server to tunnel SPDY over the WebSocket
+	websocketTunnelingPrefix  = "SPDY/3.1+"
+	websocketPingPeriod       = 10 * time.Second
+	websocketHandshakeTimeout = 45 * time.Second
+)
+
+func (t Transport) String() string {
+	switch t {
+	case TransportSPDY:
+		return "spdy"
+	case TransportWebSocket:
+		return "websocket"
+	case TransportWebSocketWithFallback:
+		return "websocket with spdy fallback"
+	default:
+		return fmt.Sprintf("Transport(%d)", int(t))
+	}
+}
+
+// newDialer creates the dialer of the streaming connection to the portforward URL of the pod,
+// the WebSocket handshake is cancelled with the context
+func newDialer(ctx context.Context, transport Transport, restCfg *rest.Config, serverURL *url.URL) (httpstream.Dialer, error) {
+	switch transport {
+	case TransportSPDY:
+		return newSPDYDialer(restCfg, serverURL)
+	case TransportWebSocket:
+		return newWebsocketDialer(ctx, restCfg, serverURL)
+	case TransportWebSocketWithFallback:
+		primary, err := newWebsocketDialer(ctx, restCfg, serverURL)
+		if err != nil {
+			return nil, err
+		}
+
+		secondary, err := newSPDYDialer(restCfg, serverURL)
+		if err != nil {
+			return nil, err
+		}
+
+		return &fallbackDialer{primary: primary, secondary: secondary}, nil
+	default:
+		return nil, fmt.Errorf("unknown transport %s", transport)
+	}
+}
+
+func newSPDYDialer(restCfg *rest.Config, serverURL *url.URL) (httpstream.Dialer, error) {
+	roundTripper, upgrader, err := spdy.RoundTripperFor(restCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return spdy.NewDialer(
+		upgrader,
+		&http.Client{Transport: roundTripper},
+		http.MethodPost,
+		serverURL,
+	), nil
+}
+
+// fallbackDialer dials with the secondary dialer when the server rejects the handshake of the primary one
+type fallbackDialer struct {
+	primary, secondary httpstream.Dialer
+}
+
+func (d *fallbackDialer) Dial(protocols ...string) (httpstream.Connection, string, error) {
+	conn, protocol, err := d.primary.Dial(protocols...)
+	if err != nil && errors.Is(err, websocket.ErrBadHandshake) {
+		return d.secondary.Dial(protocols...)
+	}
+
+	return conn, protocol, err
+}
+
+// websocketDialer opens the WebSocket to the API server and runs SPDY through it,
+// the same way the tunneling dialer of newer kubectl does
+type websocketDialer struct {
+	// ctx of the handshake, the established connection outlives it
+	ctx          context.Context
+	roundTripper http.RoundTripper
+	serverURL    *url.URL
+}
+
+func newWebsocketDialer(ctx context.Context, restCfg *rest.Config, serverURL *url.URL) (*websocketDialer, error) {
+	tlsConfig, err := rest.TLSConfigFor(restCfg)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if restCfg.Proxy != nil {
+		proxy = restCfg.Proxy
+	}
+
+	// the wrappers add the authentication and impersonation headers of the rest config
+	roundTripper, err := rest.HTTPWrappersForConfig(restCfg, &websocketRoundTripper{
+		dialer: &websocket.Dialer{
+			Proxy:            proxy,
+			TLSClientConfig:  tlsConfig,
+			HandshakeTimeout: websocketHandshakeTimeout,
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &websocketDialer{ctx: ctx, roundTripper: roundTripper, serverURL: serverURL}, nil
+}
+
+func (d *websocketDialer) Dial(protocols ...string) (httpstream.Connection, string, error) {
+	handshake := &websocketHandshake{protocols: make([]string, 0, len(protocols))}
+	for _, p := range protocols {
+		handshake.protocols = append(handshake.protocols, websocketTunnelingPrefix+p)
+	}
+
+	// WebSockets require the GET method
+	req, err := http.NewRequestWithContext(
+		context.WithValue(d.ctx, websocketHandshakeKey{}, handshake),
+		http.MethodGet,
+		d.serverURL.String(),
+		nil,
+	)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if _, err := d.roundTripper.RoundTrip(req); err != nil {
+		return nil, "", err
+	}
+
+	// a proxy or an older API server may complete the upgrade without agreeing to tunnel the streams
+	subprotocol := handshake.conn.Subprotocol()
+	if !strings.HasPrefix(subprotocol, websocketTunnelingPrefix) {
+		_ = handshake.conn.Close()
+		return nil, "", fmt.Errorf("%w: tunneling subprotocol is not negotiated, got %q", websocket.ErrBadHandshake, subprotocol)
+	}
+
+	protocol := strings.TrimPrefix(subprotocol, websocketTunnelingPrefix)
+	spdyConn, err := spdystream.NewClientConnectionWithPings(newTunnelConn(handshake.conn), websocketPingPeriod)
+	if err != nil {
+		_ = handshake.conn.Close()
+		return nil, "", err
+	}
+
+	return spdyConn, protocol, nil
+}
+
+type websocketHandshakeKey struct{}
+
+// websocketHandshake carries the requested subprotocols to the round tripper
+// and the established connection back to the dialer
+type websocketHandshake struct {
+	protocols []string
+	conn      *websocket.Conn
+}
+
+// websocketRoundTripper performs the WebSocket handshake for the request
+type websocketRoundTripper struct {
+	dialer *websocket.Dialer
+}
+
+func (rt *websocketRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	handshake, ok := req.Context().Value(websocketHandshakeKey{}).(*websocketHandshake)
+	if !ok {
+		return nil, errors.New("websocket round tripper is used outside of the websocket dialer")
+	}
+
+	u := *req.URL
+	switch u.Scheme {
+	case "https":
+		u.Scheme = "wss"
+	case "http":
+		u.Scheme = "ws"
+	}
+
+	dialer := *rt.dialer
+	dialer.Subprotocols = handshake.protocols
+
+	conn, resp, err := dialer.DialContext(req.Context(), u.String(), req.Header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("%w: %s", err, resp.Status)
+		}
+		return nil, fmt.Errorf("error dialing websocket: %w", err)
+	}
+
+	handshake.conn = conn
+	return resp, nil
+}
+
+// tunnelConn is the net.Conn carrying the SPDY frames in the binary WebSocket messages
+type tunnelConn struct {
+	conn    *websocket.Conn
+	readMx  sync.Mutex
+	writeMx sync.Mutex
+	reader  io.Reader
+	closer  sync.Once
+}
+
+var _ net.Conn = (*tunnelConn)(nil)
+
+func newTunnelConn(conn *websocket.Conn) *tunnelConn {
+	return &tunnelConn{conn: conn}
+}
+
+func (c *tunnelConn) Read(p []byte) (int, error) {
+	c.readMx.Lock()
+	defer c.readMx.Unlock()
+
+	for {
+		if c.reader == nil {
+			messageType, r, err := c.conn.NextReader()
+			if err != nil {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+					return 0, io.EOF
+				}
+				return 0, err
+			}
+
+			if messageType != websocket.BinaryMessage {
+				return 0, fmt.Errorf("unexpected websocket message type %d", messageType)
+			}
+			c.reader = r
+		}
+
+		n, err := c.reader.Read(p)
+		if errors.Is(err, io.EOF) {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *tunnelConn) Write(p []byte) (int, error) {
+	c.writeMx.Lock()
+	defer c.writeMx.Unlock()
+
+	if err := c.conn.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *tunnelConn) Close() error {
+	var err error
+	c.closer.Do(func() {
+		c.writeMx.Lock()
+		_ = c.conn.WriteControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+			time.Now().Add(time.Second),
+		)
+		c.writeMx.Unlock()
+		err = c.conn.Close()
+	})
+	return err
+}
+
+func (c *tunnelConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *tunnelConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *tunnelConn) SetDeadline(t time.Time) error {
+	if err := c.conn.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *tunnelConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *tunnelConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
@@ -0,0 +1,269 @@
+package portforwarder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"k8s.io/apimachinery/pkg/util/httpstream/spdy"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+// websocketPodServer answers like an API server tunneling SPDY over WebSockets,
+// the data streams are echoed back the same way echoPodDialer does
+type websocketPodServer struct {
+	// handleData - optional handler of the data streams instead of the echo
+	handleData func(s httpstream.Stream)
+	// handleError - optional handler of the error streams, they are closed by default
+	handleError func(s httpstream.Stream)
+	// podExists - optional check of the portforward URL path, the pod is not found when it fails
+	podExists func(path string) bool
+}
+
+func newWebsocketPodServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	return (&websocketPodServer{}).start(t)
+}
+
+func (ps *websocketPodServer) start(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	upgrader := websocket.Upgrader{
+		Subprotocols: []string{websocketTunnelingPrefix + portforward.PortForwardProtocolV1Name},
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if ps.podExists != nil && !ps.podExists(r.URL.Path) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		wsConn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		spdyConn, err := spdy.NewServerConnection(newTunnelConn(wsConn), func(s httpstream.Stream, _ <-chan struct{}) error {
+			if ps.handleData != nil && s.Headers().Get(corev1.StreamType) == corev1.StreamTypeData {
+				go ps.handleData(s)
+				return nil
+			}
+			if ps.handleError != nil && s.Headers().Get(corev1.StreamType) == corev1.StreamTypeError {
+				go ps.handleError(s)
+				return nil
+			}
+
+			go echoStream(s)
+			return nil
+		})
+		if err != nil {
+			return
+		}
+		<-spdyConn.CloseChan()
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv
+}
+
+type failingDialer struct {
+	err error
+}
+
+func (d *failingDialer) Dial(_ ...string) (httpstream.Connection, string, error) {
+	return nil, "", d.err
+}
+
+func Test_websocketDialer(t *testing.T) {
+	srv := newWebsocketPodServer(t)
+	serverURL, err := url.Parse(srv.URL + "/api/v1/namespaces/default/pods/api-1/portforward")
+	require.NoError(t, err)
+
+	dialer, err := newDialer(context.TODO(), TransportWebSocket, &rest.Config{Host: srv.URL, BearerToken: "token"}, serverURL)
+	require.NoError(t, err)
+
+	lp := newNetListenerProvider("tcp")
+	listeners, err := lp.listen([]string{"127.0.0.1"}, 0)
+	require.NoError(t, err)
+
+	process := newPortForwardProcess(context.TODO(), []*forwardedPort{newForwardedPort(listeners, 8080)}, nil, nil)
+	process.wg.Add(1)
+	go func() {
+		defer process.wg.Done()
+		_ = (&streamForwarder{}).forward(
+			dialer, process.ports, process.connCh, process.hooks(),
+			process.stopCh, process.startedCh,
+		)
+	}()
+
+	select {
+	case <-process.Started():
+	case <-time.After(5 * time.Second):
+		t.Fatal("forwarder did not start")
+	}
+
+	conn, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", process.Port))
+	require.NoError(t, err)
+
+	_, err = conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	require.NoError(t, conn.(*net.TCPConn).CloseWrite())
+
+	resp, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Equal(t, "8080:ping", string(resp))
+	require.NoError(t, conn.Close())
+
+	process.Stop()
+	<-process.Finished()
+}
+
+func Test_websocketDialer_rejected(t *testing.T) {
+	srv := newWebsocketPodServer(t)
+	serverURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	dialer, err := newWebsocketDialer(context.TODO(), &rest.Config{Host: srv.URL}, serverURL)
+	require.NoError(t, err)
+
+	_, _, err = dialer.Dial(portforward.PortForwardProtocolV1Name)
+	require.ErrorIs(t, err, websocket.ErrBadHandshake)
+	assert.Contains(t, err.Error(), "401 Unauthorized")
+}
+
+func Test_websocketDialer_contextDone(t *testing.T) {
+	// the server never answers the handshake
+	hang := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hang
+	}))
+	t.Cleanup(srv.Close)
+	t.Cleanup(func() { close(hang) })
+
+	serverURL, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	dialer, err := newWebsocketDialer(ctx, &rest.Config{Host: srv.URL, BearerToken: "token"}, serverURL)
+	require.NoError(t, err)
+
+	dialed := make(chan error, 1)
+	go func() {
+		_, _, err := dialer.Dial(portforward.PortForwardProtocolV1Name)
+		dialed <- err
+	}()
+
+	select {
+	case err := <-dialed:
+		require.Error(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("handshake is not cancelled with the context")
+	}
+}
+
+func Test_fallbackDialer(t *testing.T) {
+	t.Run("falls back when the handshake is rejected", func(t *testing.T) {
+		secondary := &echoPodDialer{}
+		d := &fallbackDialer{
+			primary:   &failingDialer{err: fmt.Errorf("%w: 400 Bad Request", websocket.ErrBadHandshake)},
+			secondary: secondary,
+		}
+
+		conn, protocol, err := d.Dial(portforward.PortForwardProtocolV1Name)
+		require.NoError(t, err)
+		assert.Equal(t, portforward.PortForwardProtocolV1Name, protocol)
+		require.NoError(t, conn.Close())
+		require.NotNil(t, secondary.server)
+	})
+
+	t.Run("falls back when the tunneling subprotocol is not negotiated", func(t *testing.T) {
+		closed := make(chan struct{})
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			wsConn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer wsConn.Close()
+
+			for {
+				if _, _, err := wsConn.ReadMessage(); err != nil {
+					close(closed)
+					return
+				}
+			}
+		}))
+		t.Cleanup(srv.Close)
+
+		serverURL, err := url.Parse(srv.URL)
+		require.NoError(t, err)
+
+		primary, err := newWebsocketDialer(context.TODO(), &rest.Config{Host: srv.URL}, serverURL)
+		require.NoError(t, err)
+
+		secondary := &echoPodDialer{}
+		d := &fallbackDialer{primary: primary, secondary: secondary}
+
+		conn, protocol, err := d.Dial(portforward.PortForwardProtocolV1Name)
+		require.NoError(t, err)
+		assert.Equal(t, portforward.PortForwardProtocolV1Name, protocol)
+		require.NoError(t, conn.Close())
+		require.NotNil(t, secondary.server)
+
+		select {
+		case <-closed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("websocket connection is not closed")
+		}
+	})
+
+	t.Run("other errors are returned", func(t *testing.T) {
+		secondary := &echoPodDialer{}
+		d := &fallbackDialer{
+			primary:   &failingDialer{err: errors.New("connection refused")},
+			secondary: secondary,
+		}
+
+		_, _, err := d.Dial(portforward.PortForwardProtocolV1Name)
+		require.EqualError(t, err, "connection refused")
+		assert.Nil(t, secondary.server)
+	})
+}
+
+func Test_newDialer(t *testing.T) {
+	serverURL, err := url.Parse("https://127.0.0.1:6443")
+	require.NoError(t, err)
+
+	for transport, want := range map[Transport]any{
+		TransportWebSocket:             &websocketDialer{},
+		TransportWebSocketWithFallback: &fallbackDialer{},
+	} {
+		d, err := newDialer(context.TODO(), transport, &rest.Config{}, serverURL)
+		require.NoError(t, err)
+		assert.IsType(t, want, d, transport.String())
+	}
+
+	_, err = newDialer(context.TODO(), TransportSPDY, &rest.Config{}, serverURL)
+	require.NoError(t, err)
+
+	_, err = newDialer(context.TODO(), Transport(42), &rest.Config{}, serverURL)
+	require.Error(t, err)
+}
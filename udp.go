@@ -0,0 +1,309 @@
+package portforwarder
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// udpIdleTimeout closes the stream of a local peer which sent nothing for that long,
+	// the same as the conntrack timeout of the UDP streams
+	udpIdleTimeout = 2 * time.Minute
+	// udpPeerQueueSize is the number of datagrams queued for the stream of a peer,
+	// the rest is dropped as the network would do
+	udpPeerQueueSize = 64
+	maxDatagramSize  = 65535
+)
+
+// writeDatagram writes the datagram prefixed with its 2 byte big endian length, like DNS over TCP
+func writeDatagram(w io.Writer, datagram []byte) error {
+	if len(datagram) > maxDatagramSize {
+		return fmt.Errorf("datagram of %d bytes is too large", len(datagram))
+	}
+
+	frame := make([]byte, 2+len(datagram))
+	binary.BigEndian.PutUint16(frame, uint16(len(datagram)))
+	copy(frame[2:], datagram)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// readDatagram reads the length prefixed datagram into buf, which should fit the max datagram size
+func readDatagram(r io.Reader, buf []byte) ([]byte, error) {
+	var size [2]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return nil, err
+	}
+
+	datagram := buf[:binary.BigEndian.Uint16(size[:])]
+	if _, err := io.ReadFull(r, datagram); err != nil {
+		return nil, err
+	}
+
+	return datagram, nil
+}
+
+// udpListener accepts a stream connection for every local UDP peer,
+// the datagrams of the peer are framed into the stream and the framed replies are sent back to the peer,
+// so the UDP endpoint is forwarded the same way as the TCP listeners are
+type udpListener struct {
+	conn     net.PacketConn
+	acceptCh chan net.Conn
+	closeCh  chan struct{}
+	closer   sync.Once
+	mx       sync.Mutex
+	peers    map[string]*udpPeer
+}
+
+type udpPeer struct {
+	addr      net.Addr
+	stream    net.Conn
+	datagrams chan []byte
+	done      chan struct{}
+	closer    sync.Once
+}
+
+func newUDPListener(conn net.PacketConn) *udpListener {
+	l := &udpListener{
+		conn:     conn,
+		acceptCh: make(chan net.Conn),
+		closeCh:  make(chan struct{}),
+		peers:    make(map[string]*udpPeer),
+	}
+
+	go l.readDatagrams()
+
+	return l
+}
+
+func (l *udpListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.acceptCh:
+		return conn, nil
+	case <-l.closeCh:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *udpListener) Close() error {
+	var err error
+	l.closer.Do(func() {
+		close(l.closeCh)
+		err = l.conn.Close()
+
+		l.mx.Lock()
+		defer l.mx.Unlock()
+		for _, peer := range l.peers {
+			peer.close()
+		}
+	})
+	return err
+}
+
+func (l *udpListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+func (l *udpListener) readDatagrams() {
+	defer l.Close()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		n, addr, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		peer, ok := l.peerFor(addr)
+		if !ok {
+			return
+		}
+
+		datagram := append([]byte(nil), buf[:n]...)
+		select {
+		case peer.datagrams <- datagram:
+		case <-peer.done:
+		default:
+			// the stream of the peer is stuck, drop the datagram
+		}
+	}
+}
+
+// peerFor returns the peer of the address, a new peer stream is accepted when there is none
+func (l *udpListener) peerFor(addr net.Addr) (*udpPeer, bool) {
+	l.mx.Lock()
+	peer, ok := l.peers[addr.String()]
+	l.mx.Unlock()
+	if ok {
+		return peer, true
+	}
+
+	local, stream := net.Pipe()
+	peer = &udpPeer{
+		addr:      addr,
+		stream:    stream,
+		datagrams: make(chan []byte, udpPeerQueueSize),
+		done:      make(chan struct{}),
+	}
+
+	select {
+	case l.acceptCh <- &udpConn{Conn: local, localAddr: l.Addr(), remoteAddr: addr}:
+	case <-l.closeCh:
+		_ = local.Close()
+		_ = stream.Close()
+		return nil, false
+	}
+
+	l.mx.Lock()
+	l.peers[addr.String()] = peer
+	l.mx.Unlock()
+
+	go l.writeDatagrams(peer)
+	go l.writeReplies(peer)
+
+	return peer, true
+}
+
+func (l *udpListener) removePeer(peer *udpPeer) {
+	peer.close()
+
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	if l.peers[peer.addr.String()] == peer {
+		delete(l.peers, peer.addr.String())
+	}
+}
+
+// writeDatagrams frames the datagrams of the peer into its stream until the peer is idle
+func (l *udpListener) writeDatagrams(peer *udpPeer) {
+	defer l.removePeer(peer)
+
+	idle := time.NewTimer(udpIdleTimeout)
+	defer idle.Stop()
+
+	for {
+		select {
+		case datagram := <-peer.datagrams:
+			if err := writeDatagram(peer.stream, datagram); err != nil {
+				return
+			}
+
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(udpIdleTimeout)
+		case <-idle.C:
+			return
+		case <-peer.done:
+			return
+		}
+	}
+}
+
+// writeReplies sends the framed replies from the stream back to the peer
+func (l *udpListener) writeReplies(peer *udpPeer) {
+	defer l.removePeer(peer)
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		datagram, err := readDatagram(peer.stream, buf)
+		if err != nil {
+			return
+		}
+
+		if _, err := l.conn.WriteTo(datagram, peer.addr); err != nil {
+			return
+		}
+	}
+}
+
+func (p *udpPeer) close() {
+	p.closer.Do(func() {
+		close(p.done)
+		_ = p.stream.Close()
+	})
+}
+
+// udpConn is the stream of the UDP peer, it reports the addresses of the peer and of the UDP endpoint
+type udpConn struct {
+	net.Conn
+	localAddr, remoteAddr net.Addr
+}
+
+func (c *udpConn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *udpConn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+// ServeUDPRelay is the relay to run in the pod as a sidecar or an ephemeral container,
+// it accepts the streams forwarded for the local UDP endpoints of TargetPod with UDP set
+// and exchanges the framed datagrams with the UDP target, e.g. 127.0.0.1:53,
+// it returns when the context is done or the listener fails
+func ServeUDPRelay(ctx context.Context, l net.Listener, target string) error {
+	go func() {
+		<-ctx.Done()
+		_ = l.Close()
+	}()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		go relayDatagrams(ctx, conn, target)
+	}
+}
+
+func relayDatagrams(ctx context.Context, stream io.ReadWriteCloser, target string) {
+	defer stream.Close()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", target)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				if !errors.Is(err, net.ErrClosed) {
+					_ = stream.Close()
+				}
+				return
+			}
+
+			if err := writeDatagram(stream, buf[:n]); err != nil {
+				return
+			}
+		}
+	}()
+
+	buf := make([]byte, maxDatagramSize)
+	for {
+		datagram, err := readDatagram(stream, buf)
+		if err != nil {
+			return
+		}
+
+		if _, err := conn.Write(datagram); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package portforwarder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/util/httpstream"
+	"net"
+	"testing"
+	"time"
+)
+
+// newUDPEchoServer answers every datagram with the datagram prefixed with "echo:"
+func newUDPEchoServer(t *testing.T) string {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+
+	go func() {
+		buf := make([]byte, maxDatagramSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = conn.WriteTo(append([]byte("echo:"), buf[:n]...), addr)
+		}
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+func exchangeDatagram(t *testing.T, conn net.Conn, datagram string) string {
+	t.Helper()
+
+	require.NoError(t, conn.SetDeadline(time.Now().Add(5*time.Second)))
+	_, err := conn.Write([]byte(datagram))
+	require.NoError(t, err)
+
+	buf := make([]byte, maxDatagramSize)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	return string(buf[:n])
+}
+
+func Test_datagramFraming(t *testing.T) {
+	var stream bytes.Buffer
+	require.NoError(t, writeDatagram(&stream, []byte("first")))
+	require.NoError(t, writeDatagram(&stream, []byte{}))
+	require.NoError(t, writeDatagram(&stream, []byte("second")))
+	assert.Equal(t, []byte{0, 5}, stream.Bytes()[:2])
+
+	buf := make([]byte, maxDatagramSize)
+	for _, want := range []string{"first", "", "second"} {
+		datagram, err := readDatagram(&stream, buf)
+		require.NoError(t, err)
+		assert.Equal(t, want, string(datagram))
+	}
+
+	require.Error(t, writeDatagram(&stream, make([]byte, maxDatagramSize+1)))
+}
+
+func Test_udpForwarding(t *testing.T) {
+	target := newUDPEchoServer(t)
+
+	lp := newNetListenerProvider("udp")
+	listeners, err := lp.listen([]string{"127.0.0.1"}, 0)
+	require.NoError(t, err)
+
+	process := newPortForwardProcess(context.TODO(), []*forwardedPort{newForwardedPort(listeners, 5353)}, nil, nil)
+	dialer := &echoPodDialer{
+		handleData: func(s httpstream.Stream) {
+			relayDatagrams(context.TODO(), s, target)
+		},
+	}
+
+	process.wg.Add(1)
+	go func() {
+		defer process.wg.Done()
+		_ = (&streamForwarder{}).forward(
+			dialer, process.ports, process.connCh, process.hooks(),
+			process.stopCh, process.startedCh,
+		)
+	}()
+
+	select {
+	case <-process.Started():
+	case <-time.After(5 * time.Second):
+		t.Fatal("forwarder did not start")
+	}
+
+	t.Run("datagrams of every peer are relayed", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			conn, err := net.Dial("udp", fmt.Sprintf("127.0.0.1:%d", process.Port))
+			require.NoError(t, err)
+
+			assert.Equal(t, "echo:query-1", exchangeDatagram(t, conn, "query-1"))
+			assert.Equal(t, "echo:query-2", exchangeDatagram(t, conn, "query-2"))
+			require.NoError(t, conn.Close())
+		}
+	})
+
+	process.Stop()
+	<-process.Finished()
+}
+
+func TestServeUDPRelay(t *testing.T) {
+	target := newUDPEchoServer(t)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- ServeUDPRelay(ctx, l, target)
+	}()
+
+	stream, err := net.Dial("tcp", l.Addr().String())
+	require.NoError(t, err)
+	require.NoError(t, stream.SetDeadline(time.Now().Add(5*time.Second)))
+
+	require.NoError(t, writeDatagram(stream, []byte("ping")))
+	reply, err := readDatagram(stream, make([]byte, maxDatagramSize))
+	require.NoError(t, err)
+	assert.Equal(t, "echo:ping", string(reply))
+	require.NoError(t, stream.Close())
+
+	cancel()
+	select {
+	case err := <-served:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("relay did not stop")
+	}
+}
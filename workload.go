@@ -0,0 +1,188 @@
+package portforwarder
+
+import (
+	"context"
+	"fmt"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type WorkloadKind string
+
+const (
+	Deployment  WorkloadKind = "Deployment"
+	StatefulSet WorkloadKind = "StatefulSet"
+	ReplicaSet  WorkloadKind = "ReplicaSet"
+	DaemonSet   WorkloadKind = "DaemonSet"
+)
+
+type TargetWorkload struct {
+	// Kind of the workload - Deployment, StatefulSet, ReplicaSet or DaemonSet
+	Kind WorkloadKind
+	// Name of the workload
+	Name string
+	// Namespace of the workload
+	Namespace string
+	// Port of the pod in k8s
+	Port uint
+	// PodSelectionStrategy - optional strategy to pick one of the ready pods owned by the workload
+	PodSelectionStrategy PodSelectionStrategy
+	// LocalPort - optional local port to forward to, a free local port is picked when not specified
+	LocalPort uint
+	// LocalAddresses - optional local addresses to listen on, e.g. 0.0.0.0, localhost by default
+	LocalAddresses []string
+	// Reconnect - optional policy to reconnect to another pod of the workload when the connection drops,
+	// e.g. during a rolling deployment
+	Reconnect *ReconnectPolicy
+	// OnEvent - optional handler of the lifecycle events
+	OnEvent EventHandler
+}
+
+func (w *TargetWorkload) applyDefaults(namespace string) {
+	if w.Namespace == "" {
+		w.Namespace = namespace
+	}
+
+	if len(w.LocalAddresses) == 0 {
+		w.LocalAddresses = defaultLocalAddresses()
+	}
+}
+
+func (w *TargetWorkload) validate() error {
+	if w.Port == 0 {
+		return fmt.Errorf("%w target port is required", ErrTargetWorkloadValidation)
+	}
+
+	if w.Namespace == "" {
+		return fmt.Errorf("%w namespace cannot be empty", ErrTargetWorkloadValidation)
+	}
+
+	if w.Name == "" {
+		return fmt.Errorf("%w workload name should be specified", ErrTargetWorkloadValidation)
+	}
+
+	switch w.Kind {
+	case Deployment, StatefulSet, ReplicaSet, DaemonSet:
+		return nil
+	default:
+		return fmt.Errorf("%w unsupported workload kind %q", ErrTargetWorkloadValidation, w.Kind)
+	}
+}
+
+// workloadSelector describes how to find the pods of a workload:
+// pods matching the selector and controlled by one of the owners
+type workloadSelector struct {
+	selector  string
+	ownerUIDs []types.UID
+}
+
+// PortForwardAWorkload resolves the workload selector via the apps/v1 API
+// and forwards a free local port to one of the pods owned by the workload
+func (pf *PortForwarder) PortForwardAWorkload(
+	ctx context.Context,
+	target *TargetWorkload,
+) (*PortForwardProcess, error) {
+	target.applyDefaults(pf.namespace())
+	if err := target.validate(); err != nil {
+		return nil, err
+	}
+
+	// the time to ready is measured from here, so it includes resolving the pod
+	metrics := pf.newForwardMetrics(target.Namespace)
+	onEvent := metrics.withMetrics(pf.withLogging(target.OnEvent, target.Namespace))
+	onEvent.emit(Resolving{Namespace: target.Namespace})
+	podName, err := pf.getWorkloadPodName(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	onEvent.emit(PodSelected{Namespace: target.Namespace, Name: podName})
+
+	return pf.forwardToPod(ctx, &forwardCommand{
+		namespace:      target.Namespace,
+		podName:        podName,
+		targetPorts:    []uint{target.Port},
+		localPort:      target.LocalPort,
+		localAddresses: target.LocalAddresses,
+		reconnect:      target.Reconnect,
+		resolvePod: func(ctx context.Context) (string, []uint, error) {
+			podName, err := pf.getWorkloadPodName(ctx, target)
+			return podName, []uint{target.Port}, err
+		},
+		onEvent: onEvent,
+		metrics: metrics,
+	})
+}
+
+// getWorkloadPodName resolves the workload selector every time,
+// since the owners of the pods change on rolling deployments
+func (pf *PortForwarder) getWorkloadPodName(ctx context.Context, target *TargetWorkload) (podName string, err error) {
+	ctx, span := pf.startResolveSpan(ctx, target.Namespace)
+	defer func() {
+		endResolveSpan(span, podName, err)
+	}()
+
+	ws, err := pf.workloadProvider.getWorkloadSelector(ctx, target.Kind, target.Namespace, target.Name)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrWorkloadNotFound, err.Error())
+	}
+
+	pod, err := resolveWorkloadPod(ctx, pf.podProvider, target, ws)
+	if err != nil {
+		return "", fmt.Errorf("could not port forward a workload: %w", err)
+	}
+
+	return pod.GetName(), nil
+}
+
+func resolveWorkloadPod(
+	ctx context.Context,
+	provider podProvider,
+	target *TargetWorkload,
+	ws *workloadSelector,
+) (*corev1.Pod, error) {
+	pods, err := provider.listPods(ctx, &listPodsCommand{
+		namespace:        target.Namespace,
+		rawLabelSelector: ws.selector,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	owned := filterOwnedPods(pods.Items, ws.ownerUIDs)
+	if len(owned) < 1 {
+		return nil, fmt.Errorf(
+			"%w: pods owned by %s %s not found in [%s] namespace",
+			ErrPodNotFound, target.Kind, target.Name, target.Namespace,
+		)
+	}
+
+	return pickPod(owned, target.PodSelectionStrategy)
+}
+
+func filterOwnedPods(pods []corev1.Pod, ownerUIDs []types.UID) []corev1.Pod {
+	owned := make([]corev1.Pod, 0, len(pods))
+	for i := range pods {
+		for _, ref := range pods[i].GetOwnerReferences() {
+			if ref.Controller == nil || !*ref.Controller {
+				continue
+			}
+
+			if containsUID(ownerUIDs, ref.UID) {
+				owned = append(owned, pods[i])
+				break
+			}
+		}
+	}
+
+	return owned
+}
+
+func containsUID(uids []types.UID, uid types.UID) bool {
+	for _, u := range uids {
+		if u == uid {
+			return true
+		}
+	}
+
+	return false
+}
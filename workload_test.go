@@ -0,0 +1,114 @@
+package portforwarder
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	"testing"
+	"time"
+)
+
+func TestPortForwarder_PortForwardAWorkload(t *testing.T) {
+	t.Run("forward a pod owned by the deployment", func(t *testing.T) {
+		ctx := context.TODO()
+		namespace := "apps"
+		ws := &workloadSelector{selector: "app=api", ownerUIDs: []types.UID{"rs-new"}}
+
+		wp := newMockWorkloadProvider(t)
+		wp.EXPECT().
+			getWorkloadSelector(ctx, Deployment, namespace, "api").
+			Times(1).
+			Return(ws, nil)
+
+		pl := newMockPodProvider(t)
+		pl.EXPECT().
+			listPods(ctx, &listPodsCommand{namespace: namespace, rawLabelSelector: "app=api"}).
+			Times(1).
+			Return(&v1.PodList{Items: []v1.Pod{
+				ownedPod("api-old-1", "rs-old"),
+				ownedPod("api-new-1", "rs-new"),
+			}}, nil)
+
+		lp := newMockListenerProvider(t)
+		lp.EXPECT().listen([]string{"localhost"}, uint(0)).Times(1).Return(newTestListeners(3999), nil)
+
+		f := newMockPortForwarder(t)
+		f.EXPECT().
+			forward(mock.Anything, matchForwardedPorts("3999:8080"), mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+			Times(1).
+			Return(nil)
+
+		pf := withCoreClient(t, &PortForwarder{
+			listenerProvider: lp,
+			podProvider:      pl,
+			workloadProvider: wp,
+			forwarder:        f,
+			restCfg:          &rest.Config{},
+		})
+
+		process, err := pf.PortForwardAWorkload(ctx, &TargetWorkload{
+			Kind:      Deployment,
+			Name:      "api",
+			Namespace: namespace,
+			Port:      8080,
+		})
+		require.NoError(t, err)
+
+		go func() {
+			<-time.After(500 * time.Millisecond)
+			process.Stop()
+		}()
+
+		<-process.Finished()
+		assert.NoError(t, process.Err())
+	})
+
+	t.Run("no owned pods", func(t *testing.T) {
+		ctx := context.TODO()
+		ws := &workloadSelector{selector: "app=db", ownerUIDs: []types.UID{"sts-uid"}}
+
+		wp := newMockWorkloadProvider(t)
+		wp.EXPECT().
+			getWorkloadSelector(ctx, StatefulSet, "default", "db").
+			Times(1).
+			Return(ws, nil)
+
+		pl := newMockPodProvider(t)
+		pl.EXPECT().
+			listPods(ctx, &listPodsCommand{namespace: "default", rawLabelSelector: "app=db"}).
+			Times(1).
+			Return(&v1.PodList{Items: []v1.Pod{ownedPod("db-0", "other-uid")}}, nil)
+
+		pf := &PortForwarder{podProvider: pl, workloadProvider: wp}
+
+		process, err := pf.PortForwardAWorkload(ctx, &TargetWorkload{
+			Kind: StatefulSet,
+			Name: "db",
+			Port: 5432,
+		})
+		require.ErrorIs(t, err, ErrPodNotFound)
+		assert.Nil(t, process)
+	})
+
+	t.Run("unsupported kind", func(t *testing.T) {
+		pf := &PortForwarder{}
+		_, err := pf.PortForwardAWorkload(context.TODO(), &TargetWorkload{
+			Kind: "CronJob",
+			Name: "backup",
+			Port: 80,
+		})
+		require.ErrorIs(t, err, ErrTargetWorkloadValidation)
+	})
+}
+
+func ownedPod(name string, ownerUID types.UID) v1.Pod {
+	controller := true
+	pod := readyPod(name)
+	pod.OwnerReferences = []metav1.OwnerReference{{UID: ownerUID, Controller: &controller}}
+	return pod
+}